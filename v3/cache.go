@@ -0,0 +1,562 @@
+package goredis
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 本地缓存默认的最大条目数
+	DefaultCacheMaxEntries = 10000
+
+	// RESP2的失效连接接收失效消息的channel
+	cacheInvalidateChannel = "__redis__:invalidate"
+)
+
+var (
+	// 集群节点不支持本地缓存
+	ErrCacheUnsupported = errors.New("redis: client side cache not supported on cluster node")
+)
+
+// 可以使用本地缓存的只读命令，命令的第一个参数为key
+var cacheableCommands = map[string]bool{
+	// strings
+	"GET": true, "GETRANGE": true, "STRLEN": true,
+	// hashes
+	"HEXISTS": true, "HGET": true, "HGETALL": true, "HKEYS": true, "HLEN": true,
+	"HMGET": true, "HSTRLEN": true, "HVALS": true,
+	// lists
+	"LINDEX": true, "LLEN": true, "LRANGE": true,
+	// sets
+	"SCARD": true, "SISMEMBER": true, "SMEMBERS": true,
+	// sorted sets
+	"ZCARD": true, "ZCOUNT": true, "ZRANGE": true, "ZRANGEBYSCORE": true, "ZRANK": true,
+	"ZREVRANGE": true, "ZREVRANK": true, "ZSCORE": true,
+}
+
+// 本地缓存的配置
+type CacheConfig struct {
+	// 最多缓存的条目数，超过后淘汰最久没有使用的条目，小于等于0时使用DefaultCacheMaxEntries
+	MaxEntries int
+	// 每个条目的最长缓存时间，为0时条目只在收到失效消息或者被淘汰时删除
+	TTL time.Duration
+}
+
+// 本地缓存的统计数据
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	// 超过MaxEntries被淘汰的条目数
+	Evictions int64
+	// 收到失效消息或者通过缓存的helper写入而删除的条目数
+	Invalidations int64
+	// 整个缓存被清空的次数，失效连接断开，服务端执行FLUSHDB等都会清空缓存
+	Flushes int64
+	// 当前缓存的条目数
+	Entries int
+}
+
+// 客户端本地缓存，通过CLIENT TRACKING在缓存的key被修改时收到失效消息，需要redis 6.0以上
+// 缓存使用一个独立的失效连接接收失效消息：RESP2连接订阅__redis__:invalidate，RESP3连接接收invalidate push；
+// 读取使用缓存自己的tracking连接池，池中的连接通过CLIENT TRACKING ON REDIRECT将失效消息重定向到失效连接，
+// 节点连接池中的连接不会开启tracking。失效连接不可用期间不使用缓存，连接断开时清空缓存并关闭tracking连接池，
+// 因为断开期间的修改不会再收到失效消息
+type Cache struct {
+	node   *Node
+	config CacheConfig
+	// 失效连接为RESP3时接收invalidate push
+	push *pushHandlers
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// 正在从redis读取的条目及其token，读取期间条目被失效时结果不写入缓存
+	pending map[string]uint64
+	// key对应的条目，包括正在读取的条目
+	keys  map[string]map[string]bool
+	token uint64
+	stats CacheStats
+	// 当前的失效连接及其CLIENT ID，连接不可用时为nil和0
+	conn     redis.Conn
+	redirect int64
+	// 重定向到当前失效连接的tracking连接池，失效连接不可用时为nil
+	tracking *redis.Pool
+	closed   bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// 缓存的条目，id由命令和参数组成
+type cacheEntry struct {
+	id     string
+	key    string
+	reply  interface{}
+	expire time.Time
+}
+
+// 新建helper绑定节点上的本地缓存，见Node.NewCache
+func (h *Helper) NewCache(config CacheConfig) (c *Cache, err error) {
+	var node *Node
+	if node, err = h.pool.GetNode(h.nodeName); err != nil {
+		return
+	}
+	return node.NewCache(config)
+}
+
+// 新建节点上的本地缓存，通过Helper.WithCache使用，不再使用时需要调用Close关闭失效连接
+// 失效连接在后台建立，断开后自动重连；集群节点返回ErrCacheUnsupported
+func (n *Node) NewCache(config CacheConfig) (*Cache, error) {
+	if n.IsCluster() {
+		return nil, ErrCacheUnsupported
+	}
+	c := newCache(n, config)
+	go c.run()
+	return c, nil
+}
+
+// 新建缓存，不建立失效连接
+func newCache(n *Node, config CacheConfig) *Cache {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultCacheMaxEntries
+	}
+	c := &Cache{
+		node:    n,
+		config:  config,
+		push:    &pushHandlers{},
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		pending: make(map[string]uint64),
+		keys:    make(map[string]map[string]bool),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	c.push.handler.Store(PushHandler(c.handlePush))
+	return c
+}
+
+// 返回一个使用cache的helper副本，副本执行的可缓存命令(GET，HGET等)优先从cache中读取，
+// 没有命中时在cache的节点上执行并缓存结果；副本执行写命令后会删除参数中的key在本地的缓存
+// 读取缓存的命令总是在master上执行，其他命令与原helper相同；cache为nil时返回不使用缓存的副本
+func (h *Helper) WithCache(cache *Cache) *Helper {
+	h2 := *h
+	h2.cache = cache
+	return &h2
+}
+
+// 获取缓存的统计数据
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// 删除keys在本地的缓存
+func (c *Cache) Invalidate(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		for id := range c.keys[key] {
+			if elem, ok := c.entries[id]; ok {
+				c.lru.Remove(elem)
+				delete(c.entries, id)
+				c.stats.Invalidations++
+			}
+			delete(c.pending, id)
+		}
+		delete(c.keys, key)
+	}
+}
+
+// 清空缓存
+func (c *Cache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flush()
+}
+
+// 在已经持有c.mu的情况下清空缓存
+func (c *Cache) flush() {
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+	c.pending = make(map[string]uint64)
+	c.keys = make(map[string]map[string]bool)
+	c.stats.Flushes++
+}
+
+// 关闭失效连接和tracking连接池并清空缓存，之后使用该缓存的helper直接在节点上执行命令
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.stop)
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.mu.Unlock()
+
+	<-c.done
+	return nil
+}
+
+// 执行helper的命令：可缓存的命令优先从缓存中读取，写命令执行后删除参数中的key在本地的缓存
+// execute为helper不使用缓存时执行命令的方法
+func (c *Cache) command(ctx context.Context, command string, args []interface{}, execute func(string, ...interface{}) (interface{}, error)) (interface{}, error) {
+	id, key, ok := cacheableCommand(command, args)
+	if !ok {
+		reply, err := execute(command, args...)
+		if !IsReadOnlyCommand(command) {
+			c.Invalidate(stringArgs(args)...)
+		}
+		return reply, err
+	}
+
+	reply, hit, token, tracking := c.get(id, key)
+	if hit {
+		return cloneReply(reply), nil
+	}
+	if tracking == nil {
+		return execute(command, args...)
+	}
+	conn, err := getConnContext(ctx, tracking)
+	if err != nil {
+		c.cancel(id, key, token)
+		if _, ok := err.(redis.Error); !ok {
+			return nil, err
+		}
+		// 开启tracking失败(如sentinel切换master后失效连接的CLIENT ID不存在)，重建失效连接，本次读取不使用缓存
+		fmt.Printf("[warning][redis] cache enable tracking fail: %s\n", err.Error())
+		c.reconnect(tracking)
+		return execute(command, args...)
+	}
+	reply, tracked, err := c.fetch(ctx, conn, command, args)
+	if tracked && err == nil {
+		c.set(id, key, token, cloneReply(reply))
+	} else {
+		c.cancel(id, key, token)
+	}
+	return reply, err
+}
+
+// 从缓存中获取条目，没有命中并且失效连接可用时登记正在读取的条目，返回登记的token和tracking连接池
+func (c *Cache) get(id, key string) (reply interface{}, hit bool, token uint64, tracking *redis.Pool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[id]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.expire.IsZero() || time.Now().Before(entry.expire) {
+			c.lru.MoveToFront(elem)
+			c.stats.Hits++
+			return entry.reply, true, 0, nil
+		}
+		c.remove(elem)
+	}
+	c.stats.Misses++
+	if c.tracking == nil {
+		return
+	}
+
+	c.token++
+	c.pending[id] = c.token
+	if c.keys[key] == nil {
+		c.keys[key] = make(map[string]bool)
+	}
+	c.keys[key][id] = true
+	return nil, false, c.token, c.tracking
+}
+
+// 将读取的结果写入缓存，读取期间条目被失效或者缓存被清空时不写入
+func (c *Cache) set(id, key string, token uint64, reply interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pending, ok := c.pending[id]; !ok || pending != token {
+		return
+	}
+	delete(c.pending, id)
+
+	entry := &cacheEntry{id: id, key: key, reply: reply}
+	if c.config.TTL > 0 {
+		entry.expire = time.Now().Add(c.config.TTL)
+	}
+	if elem, ok := c.entries[id]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[id] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.config.MaxEntries {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// 读取出错或者结果没有被追踪时取消登记
+func (c *Cache) cancel(id, key string, token uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pending, ok := c.pending[id]; !ok || pending != token {
+		return
+	}
+	delete(c.pending, id)
+	if _, ok := c.entries[id]; !ok {
+		c.unindex(id, key)
+	}
+}
+
+// 在已经持有c.mu的情况下删除条目
+func (c *Cache) remove(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, entry.id)
+	if _, ok := c.pending[entry.id]; !ok {
+		c.unindex(entry.id, entry.key)
+	}
+}
+
+// 在已经持有c.mu的情况下删除key到条目的索引
+func (c *Cache) unindex(id, key string) {
+	if ids := c.keys[key]; ids != nil {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(c.keys, key)
+		}
+	}
+}
+
+// 在tracking连接上执行命令，CLIENT CACHING YES和命令在一次往返中发送，执行完毕后释放conn
+// tracking连接使用OPTIN模式，只有紧跟在CLIENT CACHING YES之后的命令读取的key会被追踪
+// tracked为false时结果没有被追踪，不能缓存
+func (c *Cache) fetch(ctx context.Context, conn redis.Conn, command string, args []interface{}) (reply interface{}, tracked bool, err error) {
+	conn.Send("CLIENT", "CACHING", "YES")
+	conn.Send(command, args...)
+
+	var replies []interface{}
+	if replies, err = redis.Values(doContext(ctx, conn, "")); err != nil {
+		return
+	}
+	if len(replies) != 2 {
+		err = fmt.Errorf("redis: unexpected %d replies for tracking read", len(replies))
+		return
+	}
+	if replyErr, ok := replies[1].(redis.Error); ok {
+		err = replyErr
+		return
+	}
+	reply, _ = resp2Reply(replies[1], nil)
+	_, cachingErr := replies[0].(redis.Error)
+	tracked = !cachingErr
+	return
+}
+
+// 新建重定向到失效连接redirect的tracking连接池，连接池的配置与节点相同
+func (c *Cache) newTrackingPool(redirect int64) *redis.Pool {
+	nodeConf := c.node.GetConfig()
+	return &redis.Pool{
+		MaxIdle:         nodeConf.MaxIdle,
+		MaxActive:       nodeConf.MaxActive,
+		IdleTimeout:     nodeConf.IdleTimeOut,
+		MaxConnLifetime: nodeConf.MaxConnLifetime,
+		Wait:            nodeConf.Wait,
+		Dial: func() (redis.Conn, error) {
+			conn, err := c.node.GetPool().Dial()
+			if err != nil {
+				return nil, err
+			}
+			if _, err = conn.Do("CLIENT", "TRACKING", "ON", "REDIRECT", redirect, "OPTIN"); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return conn, nil
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+}
+
+// tracking连接池仍然为tracking时关闭失效连接，后台会重新建立连接
+func (c *Cache) reconnect(tracking *redis.Pool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tracking == tracking && c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+// 维持失效连接，连接断开后等待subscribeRetryInterval重连
+func (c *Cache) run() {
+	defer close(c.done)
+
+	for {
+		if err := c.receive(); err != nil {
+			fmt.Printf("[warning][redis] cache invalidation connection fail: %s\n", err.Error())
+		}
+
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(subscribeRetryInterval):
+		}
+	}
+}
+
+// 建立失效连接并接收失效消息，直到连接出错或者缓存关闭；连接断开后清空缓存
+func (c *Cache) receive() error {
+	conn, err := c.node.GetPool().Dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if rc, ok := conn.(*resp3Conn); ok {
+		// RESP3连接的失效消息为invalidate push，由缓存处理而不是Pool的PushHandler
+		rc.push = c.push
+	}
+
+	var redirect int64
+	if redirect, err = redis.Int64(conn.Do("CLIENT", "ID")); err != nil {
+		return err
+	}
+	conn.Send("SUBSCRIBE", cacheInvalidateChannel)
+	if err = conn.Flush(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.conn, c.redirect, c.tracking = conn, redirect, c.newTrackingPool(redirect)
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		tracking := c.tracking
+		c.conn, c.redirect, c.tracking = nil, 0, nil
+		c.flush()
+		c.mu.Unlock()
+		// 旧的tracking连接重定向到已经断开的失效连接，连接归还后关闭
+		drainPool(tracking)
+	}()
+
+	pingDone := make(chan struct{})
+	defer close(pingDone)
+	go pingInvalidation(conn, pingDone)
+
+	for {
+		reply, err := redis.ReceiveWithTimeout(conn, SubscribePingInterval*2)
+		if err != nil {
+			select {
+			case <-c.stop:
+				return nil
+			default:
+			}
+			return err
+		}
+		c.handleMessage(reply)
+	}
+}
+
+// 定时在失效连接上发送PING，收到的回复会重置接收的超时时间
+func pingInvalidation(conn redis.Conn, done chan struct{}) {
+	ticker := time.NewTicker(SubscribePingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		conn.Send("PING")
+		if err := conn.Flush(); err != nil {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// 处理RESP2失效连接收到的消息：[message, __redis__:invalidate, keys]
+func (c *Cache) handleMessage(reply interface{}) {
+	values, err := redis.Values(reply, nil)
+	if err != nil || len(values) != 3 {
+		return
+	}
+	if kind, _ := redis.String(values[0], nil); kind == "message" {
+		c.invalidateReply(values[2])
+	}
+}
+
+// 处理RESP3失效连接收到的invalidate push：[invalidate, keys]
+func (c *Cache) handlePush(push Push) {
+	if push.Kind == "invalidate" && len(push.Data) == 1 {
+		c.invalidateReply(push.Data[0])
+	}
+}
+
+// 删除失效消息中的key，keys为nil时表示服务端清空了数据(FLUSHDB，FLUSHALL)，清空整个缓存
+func (c *Cache) invalidateReply(reply interface{}) {
+	if reply == nil {
+		c.Flush()
+		return
+	}
+	if keys, err := redis.Strings(reply, nil); err == nil {
+		c.Invalidate(keys...)
+	}
+}
+
+// 判断命令是否可以使用缓存，返回缓存条目的id和命令读取的key
+func cacheableCommand(command string, args []interface{}) (id, key string, ok bool) {
+	command = strings.ToUpper(command)
+	if !cacheableCommands[command] || len(args) == 0 {
+		return
+	}
+	if key, ok = args[0].(string); !ok {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(command)
+	for _, arg := range args {
+		b.WriteByte(0)
+		fmt.Fprint(&b, arg)
+	}
+	return b.String(), key, true
+}
+
+// 获取参数中的字符串，写命令执行后作为可能修改了的key删除本地缓存
+func stringArgs(args []interface{}) []string {
+	keys := make([]string, 0, len(args))
+	for _, arg := range args {
+		if key, ok := arg.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// 复制缓存的结果，避免调用方修改缓存中的数据
+func cloneReply(reply interface{}) interface{} {
+	switch v := reply.(type) {
+	case []byte:
+		return append(make([]byte, 0, len(v)), v...)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = cloneReply(v[i])
+		}
+		return values
+	}
+	return reply
+}
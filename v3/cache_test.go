@@ -0,0 +1,256 @@
+package goredis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 模拟一次没有命中后的读取，返回是否命中
+func cacheRead(c *Cache, id, key string, reply interface{}) bool {
+	_, hit, token, _ := c.get(id, key)
+	if !hit {
+		c.set(id, key, token, reply)
+	}
+	return hit
+}
+
+func TestCacheStore(t *testing.T) {
+	c := newCache(nil, CacheConfig{MaxEntries: 2})
+	c.tracking = &redis.Pool{}
+
+	if cacheRead(c, "GET\x00a", "a", []byte("1")) || !cacheRead(c, "GET\x00a", "a", nil) {
+		t.Error("want miss then hit")
+	}
+	cacheRead(c, "HGET\x00b\x00f", "b", []byte("2"))
+	// 超过MaxEntries时淘汰最久没有使用的条目
+	cacheRead(c, "GET\x00a", "a", nil)
+	cacheRead(c, "GET\x00c", "c", []byte("3"))
+	if _, ok := c.keys["b"]; ok {
+		t.Error("want index of b removed")
+	}
+	if _, hit, _, _ := c.get("HGET\x00b\x00f", "b"); hit {
+		t.Error("want b evicted")
+	}
+
+	// 读取期间被失效的结果不写入缓存
+	_, _, token, _ := c.get("GET\x00d", "d")
+	c.handleMessage([]interface{}{[]byte("message"), []byte(cacheInvalidateChannel), []interface{}{[]byte("d"), []byte("a")}})
+	c.set("GET\x00d", "d", token, []byte("4"))
+	if _, hit, _, _ := c.get("GET\x00d", "d"); hit {
+		t.Error("want stale read not cached")
+	}
+	if _, hit, _, _ := c.get("GET\x00a", "a"); hit {
+		t.Error("want a invalidated")
+	}
+
+	c.handlePush(Push{Kind: "invalidate", Data: []interface{}{nil}})
+	stats := c.Stats()
+	if stats.Entries != 0 || stats.Flushes != 1 || stats.Evictions != 1 || stats.Invalidations != 1 || stats.Hits != 2 {
+		t.Error("wrong stats ", stats)
+	}
+
+	// 条目超过TTL后过期
+	c = newCache(nil, CacheConfig{TTL: time.Millisecond * 20})
+	c.tracking = &redis.Pool{}
+	cacheRead(c, "GET\x00a", "a", []byte("1"))
+	time.Sleep(time.Millisecond * 30)
+	if cacheRead(c, "GET\x00a", "a", nil) {
+		t.Error("want expired entry")
+	}
+
+	// 失效连接不可用时不缓存
+	c.tracking = nil
+	c.Flush()
+	if cacheRead(c, "GET\x00a", "a", []byte("1")) || cacheRead(c, "GET\x00a", "a", nil) {
+		t.Error("want no cache without invalidation connection")
+	}
+}
+
+func TestCacheableCommand(t *testing.T) {
+	if id, key, ok := cacheableCommand("hget", []interface{}{"user", "name"}); !ok || key != "user" || id != "HGET\x00user\x00name" {
+		t.Error("want HGET cacheable, get ", id, key, ok)
+	}
+	if _, _, ok := cacheableCommand("SET", []interface{}{"user", "name"}); ok {
+		t.Error("want SET not cacheable")
+	}
+	if _, _, ok := cacheableCommand("GET", nil); ok {
+		t.Error("want GET without key not cacheable")
+	}
+}
+
+// 启动一个处理tracking读取的服务端，返回客户端的连接和服务端收到的命令
+func newFakeTrackingConn(protocol int, replies map[string]string) (redis.Conn, <-chan []string) {
+	client, server := net.Pipe()
+	commands := make(chan []string, 10)
+	go func() {
+		defer server.Close()
+		br := bufio.NewReader(server)
+		for {
+			args, err := readFakeCommand(br)
+			if err != nil {
+				return
+			}
+			commands <- args
+			server.Write([]byte(replies[strings.ToUpper(strings.Join(args, " "))]))
+		}
+	}()
+	if protocol == ProtocolRESP3 {
+		return &resp3Conn{addr: "test", conn: client, br: bufio.NewReader(client), bw: bufio.NewWriter(client)}, commands
+	}
+	return redis.NewConn(client, time.Second, time.Second), commands
+}
+
+func TestCacheFetch(t *testing.T) {
+	c := newCache(nil, CacheConfig{})
+	for _, protocol := range []int{ProtocolRESP2, ProtocolRESP3} {
+		conn, commands := newFakeTrackingConn(protocol, map[string]string{
+			"CLIENT CACHING YES": "+OK\r\n",
+			"GET CACHE_KEY":      "$2\r\nv1\r\n",
+		})
+		reply, tracked, err := c.fetch(context.Background(), conn, "GET", []interface{}{"cache_key"})
+		if s, _ := redis.String(reply, nil); err != nil || !tracked || s != "v1" {
+			t.Error(protocol, " want tracked v1, get ", s, tracked, err)
+		}
+		// 每次读取只发送CLIENT CACHING YES和命令本身
+		if cmd := <-commands; strings.Join(cmd, " ") != "CLIENT CACHING YES" {
+			t.Error(protocol, " want CLIENT CACHING YES, get ", cmd)
+		}
+		if cmd := <-commands; strings.Join(cmd, " ") != "GET cache_key" {
+			t.Error(protocol, " want GET cache_key, get ", cmd)
+		}
+	}
+
+	// RESP3的map结果转换为RESP2格式
+	conn, _ := newFakeTrackingConn(ProtocolRESP3, map[string]string{
+		"CLIENT CACHING YES": "+OK\r\n",
+		"HGETALL CACHE_HASH": "%1\r\n$1\r\nf\r\n$1\r\nv\r\n",
+	})
+	reply, _, err := c.fetch(context.Background(), conn, "HGETALL", []interface{}{"cache_hash"})
+	if m, _ := redis.StringMap(reply, err); m["f"] != "v" {
+		t.Error("want f=v, get ", m, err)
+	}
+
+	// CLIENT CACHING失败时结果不能缓存
+	conn, _ = newFakeTrackingConn(ProtocolRESP2, map[string]string{
+		"CLIENT CACHING YES": "-ERR CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled\r\n",
+		"GET CACHE_KEY":      "$2\r\nv1\r\n",
+	})
+	if _, tracked, err := c.fetch(context.Background(), conn, "GET", []interface{}{"cache_key"}); err != nil || tracked {
+		t.Error("want untracked reply, get ", tracked, err)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	c := newCache(nil, CacheConfig{})
+	c.tracking = &redis.Pool{}
+
+	// RESP2的失效连接通过__redis__:invalidate收到失效消息
+	cacheRead(c, "GET\x00a", "a", []byte("1"))
+	conn, _ := newFakeTrackingConn(ProtocolRESP2, map[string]string{
+		"PING": "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*1\r\n$1\r\na\r\n",
+	})
+	conn.Send("PING")
+	conn.Flush()
+	reply, err := conn.Receive()
+	if err != nil {
+		t.Error(err.Error())
+	}
+	c.handleMessage(reply)
+	conn.Close()
+	if stats := c.Stats(); stats.Entries != 0 || stats.Invalidations != 1 {
+		t.Error("want a invalidated, get ", stats)
+	}
+
+	// RESP3的失效连接收到invalidate push，nil表示清空整个缓存
+	cacheRead(c, "GET\x00b", "b", []byte("2"))
+	conn, _ = newFakeTrackingConn(ProtocolRESP3, map[string]string{
+		"PING": ">2\r\n$10\r\ninvalidate\r\n_\r\n+PONG\r\n",
+	})
+	conn.(*resp3Conn).push = c.push
+	if reply, err = conn.Do("PING"); reply != "PONG" || err != nil {
+		t.Error("want PONG, get ", reply, err)
+	}
+	conn.Close()
+	if stats := c.Stats(); stats.Entries != 0 || stats.Flushes != 1 {
+		t.Error("want cache flushed, get ", stats)
+	}
+}
+
+func TestCache(t *testing.T) {
+	for _, protocol := range []int{ProtocolRESP2, ProtocolRESP3} {
+		t.Run(fmt.Sprintf("RESP%d", protocol), func(t *testing.T) {
+			p := NewPool(Config{Protocol: protocol})
+			defer p.Close()
+			p.SetNode(DefaultNodeName, "redis://127.0.0.1:6379")
+			testCache(t, p)
+		})
+	}
+}
+
+func testCache(t *testing.T, p *Pool) {
+	helper := p.NewHelper()
+	cache, err := helper.NewCache(CacheConfig{MaxEntries: 100})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer cache.Close()
+	cached := helper.WithCache(cache)
+	helper.Del("cache_key")
+
+	// 没有失效连接时直接在节点上执行
+	if err = cached.Set("cache_key", "v1"); err != nil {
+		t.Error(err.Error())
+	}
+	if s, err := redis.String(cached.Get("cache_key")); err != nil || s != "v1" {
+		t.Error("want v1, get ", s, err)
+	}
+
+	node, _ := p.GetNode(DefaultNodeName)
+	if _, err = node.Command("CLIENT", "TRACKING", "OFF"); err != nil && strings.Contains(err.Error(), "unknown") {
+		t.Skip("CLIENT TRACKING not supported on localhost:6379")
+	}
+	if !waitCache(cache, func(c *Cache) bool { return c.redirect != 0 }) {
+		t.Error("want invalidation connection")
+		return
+	}
+
+	cached.Get("cache_key")
+	if s, err := redis.String(cached.Get("cache_key")); err != nil || s != "v1" || cache.Stats().Hits == 0 {
+		t.Error("want cached v1, get ", s, err, cache.Stats())
+	}
+	// 其他连接的修改通过失效消息删除本地缓存
+	helper.Set("cache_key", "v2")
+	if !waitCache(cache, func(c *Cache) bool { return c.lru.Len() == 0 }) {
+		t.Error("want invalidated, get ", cache.Stats())
+	}
+	if s, err := redis.String(cached.Get("cache_key")); err != nil || s != "v2" {
+		t.Error("want v2, get ", s, err)
+	}
+	// 通过缓存的helper写入时立即删除本地缓存
+	cached.Set("cache_key", "v3")
+	if s, err := redis.String(cached.Get("cache_key")); err != nil || s != "v3" {
+		t.Error("want v3, get ", s, err)
+	}
+	helper.Del("cache_key")
+}
+
+// 等待缓存满足条件
+func waitCache(c *Cache, cond func(c *Cache) bool) bool {
+	for i := 0; i < 100; i++ {
+		c.mu.Lock()
+		ok := cond(c)
+		c.mu.Unlock()
+		if ok {
+			return true
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	return false
+}
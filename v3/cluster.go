@@ -0,0 +1,438 @@
+package goredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// redis集群的hash slot数量
+	ClusterSlots = 16384
+
+	// 集群命令最多跟随MOVED/ASK重定向的次数
+	clusterMaxRedirects = 5
+)
+
+var (
+	// 集群的拓扑信息获取失败
+	ErrClusterTopology = errors.New("cluster topology not available")
+)
+
+// 集群客户端，负责维护slot到master节点的映射，并将命令路由到正确的分片
+type clusterClient struct {
+	seeds    []string
+	password string
+	config   Config
+
+	mu    sync.RWMutex
+	pools map[string]*redis.Pool
+	// slot对应的master节点地址
+	slots []string
+
+	// 正在刷新拓扑时为1
+	refreshing int32
+}
+
+// 新建集群客户端，拓扑在第一次执行命令时获取
+func newClusterClient(seeds []string, password string, nodeConf Config) *clusterClient {
+	return &clusterClient{
+		seeds:    seeds,
+		password: password,
+		config:   nodeConf,
+		pools:    make(map[string]*redis.Pool),
+		slots:    make([]string, ClusterSlots),
+	}
+}
+
+// 获取某个分片地址的连接池，不存在时创建
+func (c *clusterClient) getPool(addr string) *redis.Pool {
+	c.mu.RLock()
+	p, exist := c.pools[addr]
+	c.mu.RUnlock()
+	if exist {
+		return p
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, exist = c.pools[addr]; !exist {
+		p = newAddrPool(addr, c.password, 0, c.config)
+		c.pools[addr] = p
+	}
+	return p
+}
+
+// 新建连接某个地址的redis连接池
+func newAddrPool(addr, password string, db int, nodeConf Config) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:         nodeConf.MaxIdle,
+		MaxActive:       nodeConf.MaxActive,
+		IdleTimeout:     nodeConf.IdleTimeOut,
+		MaxConnLifetime: nodeConf.MaxConnLifetime,
+		Wait:            nodeConf.Wait,
+		Dial: func() (redis.Conn, error) {
+			return dialAddr(addr, password, db, nodeConf)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+}
+
+// 连接某个地址(host:port)
+func dialAddr(addr, password string, db int, nodeConf Config) (redis.Conn, error) {
+	if nodeConf.Protocol == ProtocolRESP3 {
+		return dialRESP3(addr, false, "", password, db, nodeConf)
+	}
+	var nc *deadlineNetConn
+	c, err := redis.Dial("tcp", addr,
+		redis.DialPassword(password),
+		redis.DialDatabase(db),
+		dialNetOption(nodeConf, &nc),
+		redis.DialReadTimeout(nodeConf.readTimeout()),
+		redis.DialWriteTimeout(nodeConf.writeTimeout()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return setClientName(deadlineConn{Conn: c, nc: nc}, nodeConf)
+}
+
+// 使用release释放集群所有分片的连接池
+func (c *clusterClient) close(release func(*redis.Pool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr, p := range c.pools {
+		delete(c.pools, addr)
+		release(p)
+	}
+}
+
+// 获取所有master节点的地址
+func (c *clusterClient) masters() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	addrs := make([]string, 0)
+	for _, addr := range c.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
+
+// 获取所有master节点的地址，拓扑还未获取时同步刷新一次
+func (c *clusterClient) loadMasters() (addrs []string, err error) {
+	if addrs = c.masters(); len(addrs) > 0 {
+		return
+	}
+	if err = c.refresh(); err != nil {
+		return
+	}
+	if addrs = c.masters(); len(addrs) == 0 {
+		err = ErrClusterTopology
+	}
+	return
+}
+
+// 获取slot对应的master节点地址，拓扑还未获取时同步刷新一次
+func (c *clusterClient) slotAddr(slot int) (addr string, err error) {
+	c.mu.RLock()
+	addr = c.slots[slot]
+	c.mu.RUnlock()
+	if addr != "" {
+		return
+	}
+
+	if err = c.refresh(); err != nil {
+		return
+	}
+	c.mu.RLock()
+	addr = c.slots[slot]
+	c.mu.RUnlock()
+	if addr == "" {
+		err = ErrClusterTopology
+	}
+	return
+}
+
+// 获取一个随机的master节点地址，用于执行没有key的命令
+func (c *clusterClient) randomAddr() (addr string, err error) {
+	addrs := c.masters()
+	if len(addrs) == 0 {
+		if err = c.refresh(); err != nil {
+			return
+		}
+		if addrs = c.masters(); len(addrs) == 0 {
+			err = ErrClusterTopology
+			return
+		}
+	}
+	return addrs[rand.Intn(len(addrs))], nil
+}
+
+// 在后台刷新拓扑，已经在刷新时直接返回
+func (c *clusterClient) refreshAsync() {
+	if !atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&c.refreshing, 0)
+		c.refresh()
+	}()
+}
+
+// 依次向已知的节点和种子节点发送CLUSTER SLOTS刷新slot映射，任意一个成功即返回
+func (c *clusterClient) refresh() (err error) {
+	addrs := c.masters()
+	addrs = append(addrs, c.seeds...)
+
+	for _, addr := range addrs {
+		var slots []string
+		if slots, err = c.fetchSlots(addr); err == nil {
+			c.mu.Lock()
+			c.slots = slots
+			c.mu.Unlock()
+			return
+		}
+	}
+	if err == nil {
+		err = ErrClusterTopology
+	}
+	return
+}
+
+// 通过某个节点获取CLUSTER SLOTS并转换为slot到master地址的映射
+func (c *clusterClient) fetchSlots(addr string) (slots []string, err error) {
+	var ranges []interface{}
+
+	conn := c.getPool(addr).Get()
+	defer conn.Close()
+
+	if ranges, err = redis.Values(conn.Do("CLUSTER", "SLOTS")); err != nil {
+		return
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	slots = make([]string, ClusterSlots)
+	for _, item := range ranges {
+		var (
+			info  []interface{}
+			start int
+			end   int
+		)
+		// 每一项的格式：start end [ip port id] [replica...]
+		if info, err = redis.Values(item, nil); err != nil || len(info) < 3 {
+			return nil, fmt.Errorf("redis: unexpected CLUSTER SLOTS reply %v", item)
+		}
+		if start, err = redis.Int(info[0], nil); err != nil {
+			return
+		}
+		if end, err = redis.Int(info[1], nil); err != nil {
+			return
+		}
+		var master string
+		if master, err = parseClusterAddr(info[2], host); err != nil {
+			return
+		}
+		for slot := start; slot <= end && slot < ClusterSlots; slot++ {
+			slots[slot] = master
+		}
+	}
+	return
+}
+
+// 解析CLUSTER SLOTS中的节点信息，ip为空时使用查询节点的host
+func parseClusterAddr(item interface{}, defaultHost string) (addr string, err error) {
+	var (
+		info []interface{}
+		ip   string
+		port int
+	)
+	if info, err = redis.Values(item, nil); err != nil || len(info) < 2 {
+		return "", fmt.Errorf("redis: unexpected CLUSTER SLOTS node %v", item)
+	}
+	if ip, err = redis.String(info[0], nil); err != nil {
+		return
+	}
+	if port, err = redis.Int(info[1], nil); err != nil {
+		return
+	}
+	if ip == "" || ip == "?" {
+		ip = defaultHost
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(port)), nil
+}
+
+// 将命令路由到key所在的分片执行，并跟随MOVED/ASK重定向
+// 没有key的命令会在随机的master节点上执行，多个key的命令要求所有key在同一个slot中
+func (c *clusterClient) do(ctx context.Context, command string, args ...interface{}) (reply interface{}, err error) {
+	var (
+		addr   string
+		asking bool
+	)
+
+	if key, ok := commandKey(command, args); ok {
+		addr, err = c.slotAddr(Slot(key))
+	} else {
+		addr, err = c.randomAddr()
+	}
+	if err != nil {
+		return
+	}
+
+	for i := 0; i <= clusterMaxRedirects; i++ {
+		var conn redis.Conn
+		if conn, err = getConnContext(ctx, c.getPool(addr)); err != nil {
+			return
+		}
+		if asking {
+			conn.Send("ASKING")
+		}
+		reply, err = doContext(ctx, conn, command, args...)
+
+		redirect, ok := err.(redis.Error)
+		if !ok {
+			if err != nil && ctx.Err() == nil {
+				// 网络错误可能是节点下线导致的，刷新拓扑以便后续的命令路由到新的节点
+				c.refreshAsync()
+			}
+			return
+		}
+		kind, slot, target := parseRedirect(redirect)
+		switch kind {
+		case "MOVED":
+			c.mu.Lock()
+			c.slots[slot] = target
+			c.mu.Unlock()
+			c.refreshAsync()
+			addr, asking = target, false
+		case "ASK":
+			addr, asking = target, true
+		default:
+			return
+		}
+	}
+	return
+}
+
+// 解析MOVED/ASK错误，返回重定向类型，slot和目标地址
+func parseRedirect(err redis.Error) (kind string, slot int, addr string) {
+	fields := strings.Fields(string(err))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return
+	}
+	slot, convErr := strconv.Atoi(fields[1])
+	if convErr != nil || slot < 0 || slot >= ClusterSlots {
+		return "", 0, ""
+	}
+	return fields[0], slot, fields[2]
+}
+
+// 没有key参数的命令
+var keylessCommands = map[string]bool{
+	"AUTH": true, "BGREWRITEAOF": true, "BGSAVE": true, "CLIENT": true, "CLUSTER": true,
+	"COMMAND": true, "CONFIG": true, "DBSIZE": true, "ECHO": true, "FLUSHALL": true,
+	"FLUSHDB": true, "FUNCTION": true, "INFO": true, "LASTSAVE": true,
+	"PING": true, "PUBLISH": true, "PUBSUB": true, "RANDOMKEY": true, "ROLE": true,
+	"SAVE": true, "SCAN": true, "SCRIPT": true, "SLOWLOG": true, "TIME": true,
+	"WAIT": true,
+}
+
+// 获取命令中用于路由的key，没有key的命令返回false
+func commandKey(command string, args []interface{}) (key string, ok bool) {
+	command = strings.ToUpper(command)
+	if keylessCommands[command] {
+		return "", false
+	}
+
+	index := 0
+	switch command {
+	case "BITOP", "OBJECT", "XGROUP", "XINFO":
+		// 第一个参数为子命令或操作，如XGROUP CREATE key group id，BITOP operation destkey key [key ...]，HELP子命令没有key
+		if len(args) == 0 || strings.EqualFold(string(argBytes(args[0])), "HELP") {
+			return "", false
+		}
+		index = 1
+	case "MEMORY":
+		// 只有MEMORY USAGE key有key
+		if len(args) == 0 || !strings.EqualFold(string(argBytes(args[0])), "USAGE") {
+			return "", false
+		}
+		index = 1
+	case "EVAL", "EVALSHA", "EVAL_RO", "EVALSHA_RO", "FCALL", "FCALL_RO":
+		// EVAL script numkeys key [key ...] arg [arg ...]
+		if len(args) < 3 {
+			return "", false
+		}
+		if numKeys, err := redis.Int(argBytes(args[1]), nil); err != nil || numKeys == 0 {
+			return "", false
+		}
+		index = 2
+	case "XREAD", "XREADGROUP":
+		// XREAD ... STREAMS key [key ...] id [id ...]
+		index = -1
+		for i, arg := range args {
+			if strings.EqualFold(string(argBytes(arg)), "STREAMS") {
+				index = i + 1
+				break
+			}
+		}
+	}
+	if index < 0 || index >= len(args) {
+		return "", false
+	}
+	return string(argBytes(args[index])), true
+}
+
+// 将命令参数转换为redis协议中的字节形式
+func argBytes(arg interface{}) []byte {
+	switch v := arg.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+// 计算key所在的hash slot，key中包含{hashtag}时只计算hashtag部分
+func Slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % ClusterSlots)
+}
+
+// CRC16-CCITT(XMODEM)，redis集群计算slot使用的算法
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,109 @@
+package goredis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"github.com/vaughan0/go-ini"
+	"testing"
+)
+
+func TestSlot(t *testing.T) {
+	cases := map[string]int{
+		"123456789":     12739,
+		"foo":           12182,
+		"{foo}.bar":     12182,
+		"foo{}{bar}":    8363,
+		"foo{{bar}}zap": 4015,
+		"foo{bar}{zap}": 5061,
+		"bar":           5061,
+	}
+	for key, want := range cases {
+		if slot := Slot(key); slot != want {
+			t.Errorf("slot of %s wrong,want %d,get %d", key, want, slot)
+		}
+	}
+	if Slot("foo{}{bar}") == Slot("bar") {
+		t.Error("empty hashtag should hash the whole key")
+	}
+}
+
+func TestCommandKey(t *testing.T) {
+	cases := []struct {
+		command string
+		args    []interface{}
+		key     string
+		ok      bool
+	}{
+		{"GET", []interface{}{"name"}, "name", true},
+		{"ping", nil, "", false},
+		{"EVALSHA", []interface{}{"sha", 1, "name", "arg"}, "name", true},
+		{"EVAL", []interface{}{"return 1", 0}, "", false},
+		{"XREADGROUP", []interface{}{"GROUP", "g", "c", "STREAMS", "stream", ">"}, "stream", true},
+		{"XGROUP", []interface{}{"CREATE", "stream", "g", "$"}, "stream", true},
+		{"XINFO", []interface{}{"GROUPS", "stream"}, "stream", true},
+		{"XINFO", []interface{}{"HELP"}, "", false},
+		{"OBJECT", []interface{}{"ENCODING", "name"}, "name", true},
+		{"MEMORY", []interface{}{"USAGE", "name"}, "name", true},
+		{"MEMORY", []interface{}{"STATS"}, "", false},
+		{"BITOP", []interface{}{"AND", "dest", "a", "b"}, "dest", true},
+	}
+	for _, c := range cases {
+		key, ok := commandKey(c.command, c.args)
+		if key != c.key || ok != c.ok {
+			t.Errorf("command key of %s wrong,want %s %v,get %s %v", c.command, c.key, c.ok, key, ok)
+		}
+	}
+}
+
+func TestParseRedirect(t *testing.T) {
+	kind, slot, addr := parseRedirect(redis.Error("MOVED 3999 127.0.0.1:6381"))
+	if kind != "MOVED" || slot != 3999 || addr != "127.0.0.1:6381" {
+		t.Error("parse MOVED fail,get ", kind, slot, addr)
+	}
+	kind, slot, addr = parseRedirect(redis.Error("ASK 3999 127.0.0.1:6381"))
+	if kind != "ASK" || slot != 3999 || addr != "127.0.0.1:6381" {
+		t.Error("parse ASK fail,get ", kind, slot, addr)
+	}
+	if kind, _, _ = parseRedirect(redis.Error("ERR unknown command")); kind != "" {
+		t.Error("parse normal error as redirect")
+	}
+}
+
+func TestClusterNode(t *testing.T) {
+	p := NewPool(Config{})
+	defer p.Close()
+	p.LoadNodes(ini.File{
+		"redis_node_default": ini.Section{
+			"mode":  "cluster",
+			"seeds": "localhost:6379",
+		},
+	})
+	node, err := p.GetNode(DefaultNodeName)
+	if err != nil {
+		t.Error("get node fail,err:", err.Error())
+		return
+	}
+	if !node.IsCluster() {
+		t.Error("node should be cluster node")
+		return
+	}
+	conn := node.GetConn()
+	defer conn.Close()
+	if _, err = redis.Values(conn.Do("CLUSTER", "SLOTS")); err != nil {
+		t.Skip("cluster mode not enabled on localhost:6379")
+	}
+
+	h := p.NewHelper()
+	if err = h.Set("{user}.name", "scofield"); err != nil {
+		t.Error("set fail,err:", err.Error())
+		return
+	}
+	name, err := redis.String(h.Get("{user}.name"))
+	if err != nil {
+		t.Error("get fail,err:", err.Error())
+	} else if name != "scofield" {
+		t.Error("get wrong result,want scofield,get ", name)
+	}
+	if _, err = node.Command("PING"); err != nil {
+		t.Error("ping fail,err:", err.Error())
+	}
+}
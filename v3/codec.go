@@ -0,0 +1,250 @@
+package goredis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"io/ioutil"
+	"reflect"
+)
+
+// 对象的编码方式，用于SetObject/GetObject等方法，可以自行实现msgpack、protobuf等编码
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// 没有通过WithCodec设置编码方式时使用的编码方式
+	DefaultCodec Codec = JSONCodec{}
+
+	// 压缩数据的格式不正确
+	ErrCompressedData = errors.New("redis: invalid compressed data")
+)
+
+// JSON编码
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// gob编码，接口类型的值需要先通过gob.Register注册具体类型
+type GobCodec struct{}
+
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+const (
+	// 压缩编码的数据头，标记数据是否被压缩
+	compressNone byte = 0
+	compressGzip byte = 1
+)
+
+// 对编码后的数据进行gzip压缩，只有长度达到Threshold的数据会被压缩
+// 数据的第一个字节标记是否被压缩，因此不能直接读取其他编码方式写入的数据
+type CompressCodec struct {
+	Codec Codec
+	// 压缩的最小长度(字节)，小于等于0时总是压缩
+	Threshold int
+}
+
+// 新建压缩编码，codec为nil时使用DefaultCodec
+func NewCompressCodec(codec Codec, threshold int) *CompressCodec {
+	if codec == nil {
+		codec = DefaultCodec
+	}
+	return &CompressCodec{Codec: codec, Threshold: threshold}
+}
+
+func (c *CompressCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := c.Codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < c.Threshold {
+		return append([]byte{compressNone}, data...), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(compressGzip)
+	w := gzip.NewWriter(&buf)
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *CompressCodec) Unmarshal(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return ErrCompressedData
+	}
+	switch data[0] {
+	case compressNone:
+		return c.Codec.Unmarshal(data[1:], v)
+	case compressGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		raw, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return c.Codec.Unmarshal(raw, v)
+	}
+	return ErrCompressedData
+}
+
+// 返回一个使用codec编码对象的helper副本
+func (h *Helper) WithCodec(codec Codec) *Helper {
+	h2 := *h
+	h2.codec = codec
+	return &h2
+}
+
+// 获取helper使用的编码方式，没有设置时返回DefaultCodec
+func (h *Helper) Codec() Codec {
+	if h.codec != nil {
+		return h.codec
+	}
+	return DefaultCodec
+}
+
+// 编码多个对象
+func (h *Helper) marshalAll(values []interface{}) (args redis.Args, err error) {
+	args = make(redis.Args, 0, len(values))
+	for _, v := range values {
+		var data []byte
+		if data, err = h.Codec().Marshal(v); err != nil {
+			return
+		}
+		args = append(args, data)
+	}
+	return
+}
+
+// 解码redis返回的值，值不存在时返回ErrNil
+func (h *Helper) unmarshal(reply interface{}, err error, v interface{}) error {
+	data, err := redis.Bytes(reply, err)
+	if err != nil {
+		return err
+	}
+	return h.Codec().Unmarshal(data, v)
+}
+
+// 编码v后写入key
+func (h *Helper) SetObject(key string, v interface{}) (err error) {
+	var data []byte
+	if data, err = h.Codec().Marshal(v); err != nil {
+		return
+	}
+	_, err = h.command("SET", key, data)
+	return
+}
+
+// 编码v后写入key并设置过期时间
+func (h *Helper) SetexObject(key string, second int, v interface{}) (err error) {
+	var data []byte
+	if data, err = h.Codec().Marshal(v); err != nil {
+		return
+	}
+	_, err = h.command("SETEX", key, second, data)
+	return
+}
+
+// 读取key并解码到v，key不存在时返回ErrNil
+func (h *Helper) GetObject(key string, v interface{}) error {
+	reply, err := h.command("GET", key)
+	return h.unmarshal(reply, err, v)
+}
+
+// 编码v后写入hash的field
+func (h *Helper) HSetObject(key, field string, v interface{}) (err error) {
+	var data []byte
+	if data, err = h.Codec().Marshal(v); err != nil {
+		return
+	}
+	_, err = h.command("HSET", key, field, data)
+	return
+}
+
+// 读取hash的field并解码到v，key或field不存在时返回ErrNil
+func (h *Helper) HGetObject(key, field string, v interface{}) error {
+	reply, err := h.command("HGET", key, field)
+	return h.unmarshal(reply, err, v)
+}
+
+// 编码values后插入列表左端，返回插入后列表的长度
+func (h *Helper) LPushObject(key string, values ...interface{}) (length int64, err error) {
+	var args redis.Args
+	if args, err = h.marshalAll(values); err != nil {
+		return
+	}
+	length, err = redis.Int64(h.command("LPUSH", redis.Args{}.Add(key).Add(args...)...))
+	return
+}
+
+// 编码values后插入列表右端，返回插入后列表的长度
+func (h *Helper) RPushObject(key string, values ...interface{}) (length int64, err error) {
+	var args redis.Args
+	if args, err = h.marshalAll(values); err != nil {
+		return
+	}
+	length, err = redis.Int64(h.command("RPUSH", redis.Args{}.Add(key).Add(args...)...))
+	return
+}
+
+// 从列表左端弹出元素并解码到v，列表为空时返回ErrNil
+func (h *Helper) LPopObject(key string, v interface{}) error {
+	reply, err := h.command("LPOP", key)
+	return h.unmarshal(reply, err, v)
+}
+
+// 从列表右端弹出元素并解码到v，列表为空时返回ErrNil
+func (h *Helper) RPopObject(key string, v interface{}) error {
+	reply, err := h.command("RPOP", key)
+	return h.unmarshal(reply, err, v)
+}
+
+// 读取列表中start到stop的元素，解码后写入slice，slice需要是指向切片的指针，如*[]User
+func (h *Helper) LRangeObjects(key string, start, stop int64, slice interface{}) (err error) {
+	rv := reflect.ValueOf(slice)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("redis: LRangeObjects destination must be a pointer to slice, get %T", slice)
+	}
+
+	var replies [][]byte
+	if replies, err = redis.ByteSlices(h.command("LRANGE", key, start, stop)); err != nil {
+		return
+	}
+	sliceValue := reflect.MakeSlice(rv.Elem().Type(), len(replies), len(replies))
+	for i, data := range replies {
+		if err = h.Codec().Unmarshal(data, sliceValue.Index(i).Addr().Interface()); err != nil {
+			return
+		}
+	}
+	rv.Elem().Set(sliceValue)
+	return
+}
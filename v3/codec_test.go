@@ -0,0 +1,87 @@
+package goredis
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type testObject struct {
+	Name string
+	Tags []string
+}
+
+func TestCodec(t *testing.T) {
+	object := testObject{Name: strings.Repeat("scofield", 100), Tags: []string{"a"}}
+	codecs := []Codec{JSONCodec{}, GobCodec{}, NewCompressCodec(nil, 64), NewCompressCodec(GobCodec{}, 1<<20)}
+	for _, codec := range codecs {
+		data, err := codec.Marshal(object)
+		if err != nil {
+			t.Error(err.Error())
+			continue
+		}
+		var decoded testObject
+		if err = codec.Unmarshal(data, &decoded); err != nil {
+			t.Error(err.Error())
+			continue
+		}
+		if decoded.Name != object.Name || len(decoded.Tags) != 1 {
+			t.Errorf("%T: unexpected object %v", codec, decoded)
+		}
+	}
+
+	// 超过阈值的数据被压缩
+	raw, _ := JSONCodec{}.Marshal(object)
+	compressed, _ := NewCompressCodec(nil, 64).Marshal(object)
+	if len(compressed) >= len(raw) {
+		t.Error("want compressed data smaller than ", len(raw), ", get ", len(compressed))
+	}
+	if err := NewCompressCodec(nil, 0).Unmarshal([]byte{9}, &object); err != ErrCompressedData {
+		t.Error("want ErrCompressedData, get ", err)
+	}
+}
+
+func TestObject(t *testing.T) {
+	testInit()
+	helper := NewHelper().WithCodec(NewCompressCodec(GobCodec{}, 16))
+	helper.Del("object_test", "object_hash", "object_list")
+	defer helper.Del("object_test", "object_hash", "object_list")
+
+	object := testObject{Name: "scofield", Tags: []string{"go", "redis"}}
+	if err := helper.SetObject("object_test", object); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	var loaded testObject
+	if err := helper.GetObject("object_test", &loaded); err != nil || loaded.Name != "scofield" || len(loaded.Tags) != 2 {
+		t.Error("unexpected object ", loaded, err)
+	}
+	if err := helper.GetObject("object_not_exist", &loaded); err != ErrNil {
+		t.Error("want ErrNil, get ", err)
+	}
+	// 默认使用JSON编码
+	NewHelper().SetObject("object_test", object)
+	if data, _ := NewHelper().GetBytes("object_test"); !bytes.HasPrefix(data, []byte(`{"Name":"scofield"`)) {
+		t.Error("want JSON data, get ", string(data))
+	}
+
+	if err := helper.HSetObject("object_hash", "user", object); err != nil {
+		t.Error(err.Error())
+	}
+	loaded = testObject{}
+	if err := helper.HGetObject("object_hash", "user", &loaded); err != nil || loaded.Name != "scofield" {
+		t.Error("unexpected object ", loaded, err)
+	}
+
+	if length, err := helper.RPushObject("object_list", testObject{Name: "a"}, testObject{Name: "b"}, testObject{Name: "c"}); err != nil || length != 3 {
+		t.Error("want length 3, get ", length, err)
+	}
+	var list []testObject
+	if err := helper.LRangeObjects("object_list", 0, -1, &list); err != nil || len(list) != 3 || list[2].Name != "c" {
+		t.Error("unexpected list ", list, err)
+	}
+	loaded = testObject{}
+	if err := helper.LPopObject("object_list", &loaded); err != nil || loaded.Name != "a" {
+		t.Error("want a, get ", loaded, err)
+	}
+}
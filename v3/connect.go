@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"github.com/gomodule/redigo/redis"
 	"github.com/vaughan0/go-ini"
-	"math/rand"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,34 +19,88 @@ type (
 		MaxIdle     int
 		MaxActive   int
 		IdleTimeOut time.Duration
-		Timeout     time.Duration
-		Wait        bool
+		// 连接的最大存活时间，为0时不限制
+		MaxConnLifetime time.Duration
+		// dial时的请求,读取和写入超时时间，下面三个单独的超时时间为0时使用该值
+		Timeout        time.Duration
+		ConnectTimeout time.Duration
+		ReadTimeout    time.Duration
+		WriteTimeout   time.Duration
+		Wait           bool
+		// 检查slave健康状态的间隔，小于0时不检查
+		HealthCheckInterval time.Duration
+		// slave与master的复制偏移量差值超过该值(字节)时移出轮换，为0时不检查复制延迟
+		MaxReplicationLag int64
+		// 连接使用的协议，ProtocolRESP2(默认)或ProtocolRESP3，RESP3连接后通过HELLO 3协商，需要redis 6.0以上
+		Protocol int
+		// 连接的名称(CLIENT SETNAME)，为空时不设置
+		ClientName string
+		// WATCH的key被修改导致事务重试的最大次数，默认为DefaultTxMaxRetries，小于0时不重试
+		TxMaxRetries int
+
+		// Pool中所有节点共享的PushHandler
+		push *pushHandlers
 	}
-	// 连接池结构体
+	// 连接池结构体，每个Pool持有自己的配置和节点，互不影响
+	// 包级别的Init，GetNode，Command等函数作用在默认的Pool上
 	Pool struct {
+		mu     sync.RWMutex
 		nodes  map[string]*Node
 		config Config
+		// 关闭时停止健康检查
+		healthStop chan struct{}
+		// 通过RegisterScript注册的脚本，节点创建时预加载
+		scripts []*Script
+		// 通过RegisterFunctionLibrary注册的函数库代码，每个库名只保留最后注册的代码，Init时确保每个节点都已加载
+		libraries []string
 	}
 	// 节点
 	Node struct {
-		pool   *redis.Pool
-		slaves []string
+		mu         sync.RWMutex
+		pool       *redis.Pool
+		cluster    *clusterClient
+		sentinel   *sentinelClient
+		definition nodeDefinition
+		slaves     []string
+		selector   SlaveSelector
+		config     Config
+		// 节点所属的Pool，用于查找slave节点
+		owner *Pool
+		// 作为slave时的健康状态
+		health nodeHealth
 	}
 )
 
 var (
-	config = Config{
+	// 默认的Pool
+	pool = &Pool{
+		nodes:  make(map[string]*Node),
+		config: newPoolConfig(),
+	}
+	isInit = false
+	initMu sync.Mutex
+)
+
+// 获取默认的连接池配置
+func defaultConfig() Config {
+	return Config{
 		MaxIdle:     5,
 		MaxActive:   100,
 		IdleTimeOut: time.Second * time.Duration(DefaultIdleTimeout),
 		Timeout:     time.Second * time.Duration(DefaultTimeout),
 		Wait:        true,
+
+		HealthCheckInterval: time.Second * time.Duration(DefaultHealthCheckInterval),
+		TxMaxRetries:        DefaultTxMaxRetries,
 	}
-	pool = Pool{
-		nodes: make(map[string]*Node),
-	}
-	isInit = false
-)
+}
+
+// 获取新建Pool的配置，每个Pool有自己的PushHandler
+func newPoolConfig() Config {
+	c := defaultConfig()
+	c.push = &pushHandlers{}
+	return c
+}
 
 func (c *Config) Set(data Config) {
 	if data.MaxIdle > 0 {
@@ -55,12 +112,66 @@ func (c *Config) Set(data Config) {
 	if data.IdleTimeOut > 0 {
 		c.IdleTimeOut = data.IdleTimeOut
 	}
+	if data.MaxConnLifetime > 0 {
+		c.MaxConnLifetime = data.MaxConnLifetime
+	}
 	if data.Timeout > 0 {
 		c.Timeout = data.Timeout
 	}
+	if data.ConnectTimeout > 0 {
+		c.ConnectTimeout = data.ConnectTimeout
+	}
+	if data.ReadTimeout > 0 {
+		c.ReadTimeout = data.ReadTimeout
+	}
+	if data.WriteTimeout > 0 {
+		c.WriteTimeout = data.WriteTimeout
+	}
+	if data.HealthCheckInterval != 0 {
+		c.HealthCheckInterval = data.HealthCheckInterval
+	}
+	if data.MaxReplicationLag > 0 {
+		c.MaxReplicationLag = data.MaxReplicationLag
+	}
+	if data.Protocol > 0 {
+		c.Protocol = data.Protocol
+	}
+	if data.ClientName != "" {
+		c.ClientName = data.ClientName
+	}
+	if data.TxMaxRetries != 0 {
+		c.TxMaxRetries = data.TxMaxRetries
+	}
+	if data.push != nil {
+		c.push = data.push
+	}
 	c.Wait = data.Wait
 }
 
+// 获取dial时的连接超时时间
+func (c Config) connectTimeout() time.Duration {
+	if c.ConnectTimeout > 0 {
+		return c.ConnectTimeout
+	}
+	return c.Timeout
+}
+
+// 获取读取超时时间
+func (c Config) readTimeout() time.Duration {
+	if c.ReadTimeout > 0 {
+		return c.ReadTimeout
+	}
+	return c.Timeout
+}
+
+// 获取写入超时时间
+func (c Config) writeTimeout() time.Duration {
+	if c.WriteTimeout > 0 {
+		return c.WriteTimeout
+	}
+	return c.Timeout
+}
+
 const (
 	// 默认节点配置
 	DefaultNodeName = "default"
@@ -68,81 +179,211 @@ const (
 	DefaultIdleTimeout = 60
 	// dial时的默认请求,读取和写入超时时间
 	DefaultTimeout = 60
+	// 默认的slave健康检查间隔
+	DefaultHealthCheckInterval = 5
+	// 事务默认的最大重试次数
+	DefaultTxMaxRetries = 3
 
 	// node在config中的默认前缀
 	NodeConfigPrefix = "redis_node_"
+
+	// 单机节点，通过scheme连接
+	NodeModeStandalone = "standalone"
+	// 集群节点，通过seeds发现集群拓扑
+	NodeModeCluster = "cluster"
+	// sentinel节点，通过sentinels发现master和replica
+	NodeModeSentinel = "sentinel"
+
+	// 检查被移除的连接池是否还有连接在使用的间隔
+	drainCheckInterval = time.Millisecond * 100
 )
 
 var (
 	// 节点没有找到
 	ErrNodeNotFound = errors.New("node not found")
+	// 节点已经存在
+	ErrNodeExists = errors.New("node already exists")
+
+	// 节点被移除或替换后，等待旧连接池中的连接归还的最长时间
+	DrainTimeout = time.Second * 30
 )
 
-// 获取节点的redis连接池
+// 获取节点连接池的配置
+func (n *Node) GetConfig() Config {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.config
+}
+
+// 获取节点的redis连接池，sentinel节点返回当前master的连接池
 func (n *Node) GetPool() (p *redis.Pool) {
+	n.mu.RLock()
 	p = n.pool
+	sentinel := n.sentinel
+	n.mu.RUnlock()
+	if sentinel != nil {
+		p = sentinel.masterPool()
+	}
 	return
 }
 
-// 获取节点的redis连接结构体
+// 判断节点是否为集群节点
+func (n *Node) IsCluster() bool {
+	return n.getCluster() != nil
+}
+
+// 获取集群节点的集群客户端，非集群节点返回nil
+func (n *Node) getCluster() *clusterClient {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.cluster
+}
+
+// 获取sentinel节点的sentinel客户端，非sentinel节点返回nil
+func (n *Node) getSentinel() *sentinelClient {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.sentinel
+}
+
+// 获取节点的slave名称
+func (n *Node) slaveNames() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.slaves
+}
+
+// 获取节点的redis连接结构体，集群节点返回的是第一个种子节点的连接
 // 使用完毕后务必使用conn.Close()释放连接池
 func (n *Node) GetConn() (conn redis.Conn) {
 	return n.GetPool().Get()
 }
 
-// 使用context.Context获取redis的连接
+// 使用context.Context获取redis的连接，ctx同时约束等待空闲连接、建立新连接和检查空闲连接是否可用
 // 使用完毕后务必使用conn.Close()释放连接池
 func (n *Node) GetConnContext(ctx context.Context) (conn redis.Conn, err error) {
-	return n.GetPool().GetContext(ctx)
+	return getConnContext(ctx, n.GetPool())
+}
+
+// 在ctx的约束下从连接池获取连接
+// redis.Pool.GetContext只约束等待空闲连接，建立连接和TestOnBorrow不受ctx控制，因此在后台获取，
+// ctx结束时立即返回ctx.Err()，后台获取到的连接直接归还连接池
+func getConnContext(ctx context.Context, p *redis.Pool) (redis.Conn, error) {
+	if ctx.Done() == nil {
+		return p.GetContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		conn redis.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		r.conn, r.err = p.GetContext(ctx)
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
 }
 
 // 执行command命令
 func (n *Node) Command(command string, args ...interface{}) (response interface{}, err error) {
+	return n.CommandContext(context.Background(), command, args...)
+}
+
+// 使用context.Context执行command命令
+// ctx的deadline同时约束从连接池获取连接、写入命令和读取结果，ctx被取消时立即返回ctx.Err()
+// 集群节点会根据命令的key路由到对应的分片
+func (n *Node) CommandContext(ctx context.Context, command string, args ...interface{}) (response interface{}, err error) {
+	var conn redis.Conn
+
 	if command == "" {
 		err = errors.New("command required")
 		return
 	}
+	if cluster := n.getCluster(); cluster != nil {
+		return cluster.do(ctx, command, args...)
+	}
+	if conn, err = n.GetConnContext(ctx); err != nil {
+		return
+	}
 
-	conn := n.GetConn()
-	defer conn.Close()
-
-	return conn.Do(command, args...)
+	return doContext(ctx, conn, command, args...)
 }
 
 // 获取当前节点的所有slave节点，如果出错，返回error
+// sentinel节点还会返回通过sentinel发现的replica节点
 func (n *Node) GetSlaves() (nodes []*Node, err error) {
 	var node *Node
 
-	nodes = make([]*Node, 0, len(n.slaves))
-	if len(n.slaves) > 0 {
-		for _, v := range n.slaves {
-			if node, err = pool.GetNode(v); err == nil {
+	slaves := n.slaveNames()
+	nodes = make([]*Node, 0, len(slaves))
+	if len(slaves) > 0 {
+		for _, v := range slaves {
+			if node, err = n.owner.GetNode(v); err == nil {
 				nodes = append(nodes, node)
 			}
 		}
 	}
+	if sentinel := n.getSentinel(); sentinel != nil {
+		nodes = append(nodes, sentinel.replicaNodes()...)
+	}
 
 	return nodes, err
 }
 
-// 获取slave节点,如果不指定slave名称，那么会随机返回一个slave节点，如果查找出错，返回error
+// 获取slave节点,如果不指定slave名称，那么会通过节点的SlaveSelector从健康的slave中选择一个，如果查找出错，返回error
+// 如果所有slave都不健康，那么返回当前节点(master)本身
 // 注意，如果没有找到相关slave节点，将会返回goredis.ErrNodeNotFound错误
 func (n *Node) GetSlave(slaveName ...string) (node *Node, err error) {
-	if len(n.slaves) == 0 {
+	if len(slaveName) > 0 {
+		// sentinel发现的replica节点不属于任何Pool，没有按名称配置的slave
+		if n.owner == nil {
+			err = ErrNodeNotFound
+			return
+		}
+		node, err = n.owner.GetNode(slaveName[0])
+		return
+	}
+
+	slaves, _ := n.GetSlaves()
+	if len(slaves) == 0 {
 		err = ErrNodeNotFound
 		return
 	}
-	if len(slaveName) == 0 {
-		slaveName = make([]string, 1)
-		// 随机选择一个slave
-		rand.Seed(time.Now().UnixNano())
-		slaveName[0] = n.slaves[rand.Intn(len(n.slaves))]
+	healthySlaves := make([]*Node, 0, len(slaves))
+	for _, slave := range slaves {
+		if slave.Healthy() {
+			healthySlaves = append(healthySlaves, slave)
+		}
 	}
-
-	node, err = GetNode(slaveName[0])
+	if len(healthySlaves) == 0 {
+		node = n
+		return
+	}
+	node = n.getSlaveSelector().Select(healthySlaves)
 	return
 }
 
+// 获取默认的Pool
+func DefaultPool() *Pool {
+	return pool
+}
+
 // 获取节点
 func GetNode(node ...string) (n *Node, err error) {
 	if len(node) == 0 {
@@ -155,25 +396,50 @@ func GetNode(node ...string) (n *Node, err error) {
 // 执行命令,传入节点名称，执行的命令名，命令参数，返回的第一个参数为返回值，如果出错，第二个参数为空
 // 建议用redis.Int()等进行转义结果
 func Command(nodeName string, command string, args ...interface{}) (data interface{}, err error) {
+	return pool.CommandContext(context.Background(), nodeName, command, args...)
+}
+
+// 同Command，额外传入context.Context，用于控制超时和取消
+func CommandContext(ctx context.Context, nodeName string, command string, args ...interface{}) (data interface{}, err error) {
+	return pool.CommandContext(ctx, nodeName, command, args...)
+}
+
+// 在某个node的slave上执行命令，传入节点名称，执行的命令名，命令参数，返回的第一个参数为返回值，如果出错，第二个参数为空
+// 建议用redis.Int()等进行转义结果
+func CommandOnSlave(nodeName string, command string, args ...interface{}) (data interface{}, err error) {
+	return pool.CommandOnSlaveContext(context.Background(), nodeName, command, args...)
+}
+
+// 同CommandOnSlave，额外传入context.Context，用于控制超时和取消
+func CommandOnSlaveContext(ctx context.Context, nodeName string, command string, args ...interface{}) (data interface{}, err error) {
+	return pool.CommandOnSlaveContext(ctx, nodeName, command, args...)
+}
+
+// 在p的某个节点上执行命令，见Command
+func (p *Pool) Command(nodeName string, command string, args ...interface{}) (data interface{}, err error) {
+	return p.CommandContext(context.Background(), nodeName, command, args...)
+}
+
+// 在p的某个节点上执行命令，见CommandContext
+func (p *Pool) CommandContext(ctx context.Context, nodeName string, command string, args ...interface{}) (data interface{}, err error) {
 	var n *Node
-	n, err = GetNode(nodeName)
+	n, err = p.GetNode(nodeName)
 	if err != nil {
 		return
 	}
-	conn := n.GetConn()
-	defer conn.Close()
-	data, err = conn.Do(command, args...)
+	data, err = n.CommandContext(ctx, command, args...)
 	return
 }
 
-// 在某个node的slave上执行命令，传入节点名称，执行的命令名，命令参数，返回的第一个参数为返回值，如果出错，第二个参数为空
-// 建议用redis.Int()等进行转义结果
-func CommandOnSlave(nodeName string, command string, args ...interface{}) (data interface{}, err error) {
-	var (
-		n    *Node
-		conn redis.Conn
-	)
-	n, err = GetNode(nodeName)
+// 在p的某个节点的slave上执行命令，见CommandOnSlave
+func (p *Pool) CommandOnSlave(nodeName string, command string, args ...interface{}) (data interface{}, err error) {
+	return p.CommandOnSlaveContext(context.Background(), nodeName, command, args...)
+}
+
+// 在p的某个节点的slave上执行命令，见CommandOnSlaveContext
+func (p *Pool) CommandOnSlaveContext(ctx context.Context, nodeName string, command string, args ...interface{}) (data interface{}, err error) {
+	var n *Node
+	n, err = p.GetNode(nodeName)
 	if err != nil {
 		return
 	}
@@ -182,33 +448,154 @@ func CommandOnSlave(nodeName string, command string, args ...interface{}) (data
 		return
 	}
 
-	conn = n.GetConn()
-	defer conn.Close()
+	data, err = n.CommandContext(ctx, command, args...)
+	return
+}
+
+// 在ctx的约束下通过conn执行命令，执行完毕后释放conn
+// 如果ctx设置了deadline，那么剩余时间会作为本次命令的读取超时时间；
+// ctx结束时关闭底层的网络连接中断正在进行的读写，连接被标记为不可用并立即释放，然后返回ctx.Err()
+func doContext(ctx context.Context, conn redis.Conn, command string, args ...interface{}) (reply interface{}, err error) {
+	if ctx.Done() == nil {
+		defer conn.Close()
+		return connDo(ctx, conn, command, args...)
+	}
+	if err = ctx.Err(); err != nil {
+		conn.Close()
+		return
+	}
+
+	type result struct {
+		reply interface{}
+		err   error
+	}
+	nc := underlyingNetConn(conn)
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() {
+			conn.Close()
+			done <- r
+		}()
+
+		r.reply, r.err = connDo(ctx, conn, command, args...)
+	}()
+
+	select {
+	case r := <-done:
+		return r.reply, r.err
+	case <-ctx.Done():
+		if nc != nil {
+			nc.Close()
+			<-done
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// 阻塞命令读超时的context key
+type readTimeoutKey struct{}
 
-	data, err = conn.Do(command, args...)
+// 为阻塞命令设置读超时，覆盖连接的ReadTimeout，timeout为0时一直等待直到ctx结束
+func withReadTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, readTimeoutKey{}, timeout)
+}
+
+// 在conn上执行命令但不释放conn
+// 命令的读超时和写入的截止时间不超过ctx的deadline，ctx通过withReadTimeout设置了读超时时使用设置的读超时
+// 执行出错时如果ctx已经结束，返回ctx.Err()而不是读写超时等网络错误
+func connDo(ctx context.Context, conn redis.Conn, command string, args ...interface{}) (reply interface{}, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	timeout, ok := ctx.Value(readTimeoutKey{}).(time.Duration)
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+		if !ok || timeout == 0 || remain < timeout {
+			timeout, ok = remain, true
+		}
+	}
+	if ok {
+		reply, err = redis.DoWithTimeout(conn, timeout, command, args...)
+	} else {
+		reply, err = conn.Do(command, args...)
+	}
+	if err != nil {
+		// 连接的读超时与ctx的deadline同时到达，ctx可能还没有被标记为结束
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if hasDeadline && !time.Now().Before(deadline) {
+			return nil, context.DeadlineExceeded
+		}
+	}
 	return
+}
 
+// 获取底层网络连接的内部命令，不会发送到redis
+// redis.Pool返回的连接不暴露底层连接，因此通过Do传递这个命令，deadlineConn和resp3Conn识别后将网络连接写入参数
+const netConnCommand = "\x00GOREDIS.NETCONN"
+
+// 获取conn底层的网络连接，用于ctx结束时中断正在进行的读写，不是本包建立的连接返回nil
+func underlyingNetConn(conn redis.Conn) (nc net.Conn) {
+	if _, err := conn.Do(netConnCommand, &nc); err != nil {
+		return nil
+	}
+	return
 }
 
-// pool 初始化某个node的pool
-func (p *Pool) SetNode(nodeName, scheme string) {
-	if _, exist := p.nodes[nodeName]; !exist {
-		p.nodes[nodeName] = &Node{slaves: make([]string, 0)}
+// 处理netConnCommand，command不是netConnCommand时返回false
+func handleNetConnCommand(nc net.Conn, command string, args []interface{}) bool {
+	if command != netConnCommand {
+		return false
+	}
+	if len(args) == 1 {
+		if p, ok := args[0].(*net.Conn); ok {
+			*p = nc
+		}
+	}
+	return true
+}
+
+// 新建Pool，redisConfig中没有设置的参数使用默认配置
+// 新建的Pool不包含任何节点，可以通过LoadNodes或者AddNode添加
+func NewPool(redisConfig Config) *Pool {
+	p := &Pool{
+		nodes:  make(map[string]*Node),
+		config: newPoolConfig(),
 	}
+	p.config.Set(redisConfig)
+	return p
+}
+
+// 获取Pool的全局连接池配置
+func (p *Pool) Config() Config {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.config
+}
 
-	p.nodes[nodeName].pool = &redis.Pool{
-		MaxIdle:     config.MaxIdle,
-		IdleTimeout: time.Duration(config.IdleTimeOut) * time.Second,
+// 设置Pool的全局连接池配置，只对之后新建的节点生效，规则见Config.Set
+func (p *Pool) SetConfig(redisConfig Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.config.Set(redisConfig)
+}
+
+// 新建节点的redis连接池
+func newRedisPool(scheme string, nodeConf Config) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:         nodeConf.MaxIdle,
+		MaxActive:       nodeConf.MaxActive,
+		IdleTimeout:     nodeConf.IdleTimeOut,
+		MaxConnLifetime: nodeConf.MaxConnLifetime,
+		Wait:            nodeConf.Wait,
 		Dial: func() (redis.Conn, error) {
-			c, err := redis.DialURL(scheme,
-				redis.DialConnectTimeout(config.Timeout),
-				redis.DialReadTimeout(config.Timeout),
-				redis.DialWriteTimeout(config.Timeout),
-			)
-			if err != nil {
-				return nil, err
-			}
-			return c, err
+			return dialURL(scheme, nodeConf)
 		},
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
 			_, err := c.Do("PING")
@@ -217,17 +604,284 @@ func (p *Pool) SetNode(nodeName, scheme string) {
 	}
 }
 
+// 通过url连接redis，按照nodeConf.Protocol选择协议
+func dialURL(scheme string, nodeConf Config) (redis.Conn, error) {
+	if nodeConf.Protocol == ProtocolRESP3 {
+		return dialURLRESP3(scheme, nodeConf)
+	}
+	var nc *deadlineNetConn
+	c, err := redis.DialURL(scheme,
+		dialNetOption(nodeConf, &nc),
+		redis.DialReadTimeout(nodeConf.readTimeout()),
+		redis.DialWriteTimeout(nodeConf.writeTimeout()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return setClientName(deadlineConn{Conn: c, nc: nc}, nodeConf)
+}
+
+// 建立TCP连接的DialOption，建立的连接保存到nc中，用于限制写入的截止时间
+func dialNetOption(nodeConf Config, nc **deadlineNetConn) redis.DialOption {
+	return redis.DialNetDial(func(network, addr string) (net.Conn, error) {
+		dialer := net.Dialer{Timeout: nodeConf.connectTimeout(), KeepAlive: time.Minute * 5}
+		conn, err := dialer.Dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		*nc = &deadlineNetConn{Conn: conn}
+		return *nc, nil
+	})
+}
+
+// 可以限制写入截止时间的TCP连接，redigo设置的写入截止时间不会超过限制
+type deadlineNetConn struct {
+	net.Conn
+
+	mu    sync.Mutex
+	limit time.Time
+}
+
+func (c *deadlineNetConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	limit := c.limit
+	c.mu.Unlock()
+	if !limit.IsZero() && (t.IsZero() || limit.Before(t)) {
+		t = limit
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// 设置写入截止时间的上限，为零值时取消限制
+func (c *deadlineNetConn) limitWrite(limit time.Time) {
+	c.mu.Lock()
+	c.limit = limit
+	c.mu.Unlock()
+	c.Conn.SetWriteDeadline(limit)
+}
+
+// RESP2连接，DoWithTimeout的超时时间同时作为写入截止时间的上限，
+// 因此connDo中ctx的deadline同时约束命令的写入和读取
+type deadlineConn struct {
+	redis.Conn
+	nc *deadlineNetConn
+}
+
+func (c deadlineConn) Do(command string, args ...interface{}) (interface{}, error) {
+	if c.nc != nil && handleNetConnCommand(c.nc.Conn, command, args) {
+		return nil, nil
+	}
+	return c.Conn.Do(command, args...)
+}
+
+func (c deadlineConn) DoWithTimeout(timeout time.Duration, command string, args ...interface{}) (interface{}, error) {
+	if timeout > 0 && c.nc != nil {
+		c.nc.limitWrite(time.Now().Add(timeout))
+		defer c.nc.limitWrite(time.Time{})
+	}
+	return redis.DoWithTimeout(c.Conn, timeout, command, args...)
+}
+
+func (c deadlineConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(c.Conn, timeout)
+}
+
+// RESP2连接通过CLIENT SETNAME设置连接名称
+func setClientName(c redis.Conn, nodeConf Config) (redis.Conn, error) {
+	if nodeConf.ClientName == "" {
+		return c, nil
+	}
+	if _, err := c.Do("CLIENT", "SETNAME", nodeConf.ClientName); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// 节点持有的连接资源，节点被替换或移除时整体释放
+type nodeResources struct {
+	pool     *redis.Pool
+	cluster  *clusterClient
+	sentinel *sentinelClient
+}
+
+// 根据节点定义创建连接资源
+func newNodeResources(definition nodeDefinition) (res nodeResources) {
+	switch definition.mode {
+	case NodeModeCluster:
+		res.cluster = newClusterClient(definition.seeds, definition.password, definition.config)
+		res.pool = res.cluster.getPool(definition.seeds[0])
+		return
+	case NodeModeSentinel:
+		res.sentinel = newSentinelClient(definition)
+		return
+	}
+	res.pool = newRedisPool(definition.scheme, definition.config)
+	return
+}
+
+// 等待连接归还后释放所有连接资源，见drainPool
+func (res nodeResources) drain() {
+	if res.cluster != nil {
+		res.cluster.close(drainPool)
+		return
+	}
+	if res.sentinel != nil {
+		res.sentinel.close(drainPool)
+		return
+	}
+	drainPool(res.pool)
+}
+
+// 立即释放所有连接资源
+func (res nodeResources) close() {
+	closePool := func(redisPool *redis.Pool) {
+		redisPool.Close()
+	}
+	if res.cluster != nil {
+		res.cluster.close(closePool)
+		return
+	}
+	if res.sentinel != nil {
+		res.sentinel.close(closePool)
+		return
+	}
+	if res.pool != nil {
+		closePool(res.pool)
+	}
+}
+
+// 获取节点当前的连接资源
+func (n *Node) resources() nodeResources {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return nodeResources{pool: n.pool, cluster: n.cluster, sentinel: n.sentinel}
+}
+
+// 替换节点的连接资源和定义，返回被替换的旧连接资源
+func (n *Node) setResources(definition nodeDefinition, res nodeResources) (old nodeResources) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	old = nodeResources{pool: n.pool, cluster: n.cluster, sentinel: n.sentinel}
+	n.pool = res.pool
+	n.cluster = res.cluster
+	n.sentinel = res.sentinel
+	n.definition = definition
+	n.config = definition.config
+	return
+}
+
+// 等待连接池中正在使用的连接全部归还后关闭连接池，最多等待DrainTimeout
+// 关闭后空闲连接会被立即释放，超时后仍未归还的连接会在归还时被关闭
+func drainPool(redisPool *redis.Pool) {
+	if redisPool == nil {
+		return
+	}
+	go func() {
+		deadline := time.Now().Add(DrainTimeout)
+		for redisPool.ActiveCount() > redisPool.IdleCount() && time.Now().Before(deadline) {
+			time.Sleep(drainCheckInterval)
+		}
+		redisPool.Close()
+	}()
+}
+
+// pool 初始化某个node的pool，nodeConfig为该节点单独的连接池配置，不传时使用全局配置
+// 如果节点已经存在，那么会替换该节点的连接池，旧的连接池在连接归还后关闭
+func (p *Pool) SetNode(nodeName, scheme string, nodeConfig ...Config) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.setNode(nodeName, p.newDefinition(scheme, nodeConfig...))
+}
+
+// 根据scheme和连接池配置生成单机节点的定义
+func (p *Pool) newDefinition(scheme string, nodeConfig ...Config) nodeDefinition {
+	definition := nodeDefinition{mode: NodeModeStandalone, scheme: scheme, config: p.config}
+	if len(nodeConfig) > 0 {
+		definition.config = nodeConfig[0]
+	}
+	return definition
+}
+
+// 在已经持有p.mu的情况下设置节点
+func (p *Pool) setNode(nodeName string, definition nodeDefinition) {
+	// 单独传入的节点配置也使用Pool的PushHandler
+	definition.config.push = p.config.push
+	if _, exist := p.nodes[nodeName]; !exist {
+		p.nodes[nodeName] = &Node{slaves: make([]string, 0), owner: p}
+	}
+
+	p.nodes[nodeName].setResources(definition, newNodeResources(definition)).drain()
+	p.startHealthCheck()
+	if len(p.scripts) > 0 {
+		go p.nodes[nodeName].preloadScripts(nodeName, append([]*Script(nil), p.scripts...))
+	}
+}
+
+// 新增节点，如果节点已经存在，返回ErrNodeExists
+func (p *Pool) AddNode(nodeName, scheme string, nodeConfig ...Config) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exist := p.nodes[nodeName]; exist {
+		return ErrNodeExists
+	}
+	p.setNode(nodeName, p.newDefinition(scheme, nodeConfig...))
+	return nil
+}
+
+// 原子地替换某个节点的连接池，节点的slaves保持不变，如果节点不存在，返回ErrNodeNotFound
+// 替换后新的命令立即使用新的连接池，旧的连接池在连接归还后关闭
+func (p *Pool) ReplaceNode(nodeName, scheme string, nodeConfig ...Config) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, exist := p.nodes[nodeName]; !exist {
+		return ErrNodeNotFound
+	}
+	p.setNode(nodeName, p.newDefinition(scheme, nodeConfig...))
+	return nil
+}
+
+// 移除节点，节点的连接池在连接归还后关闭，如果节点不存在，返回ErrNodeNotFound
+func (p *Pool) RemoveNode(nodeName string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, exist := p.nodes[nodeName]
+	if !exist {
+		return ErrNodeNotFound
+	}
+	delete(p.nodes, nodeName)
+	node.resources().drain()
+	return nil
+}
+
 // 设置某个node的slaves，传入slave的node名称即可
 func (p *Pool) SetSlaves(nodeName string, slaves []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
 	if _, exist := p.nodes[nodeName]; !exist {
-		p.nodes[nodeName] = &Node{slaves: make([]string, 0)}
+		p.nodes[nodeName] = &Node{slaves: make([]string, 0), owner: p}
 	}
-	p.nodes[nodeName].slaves = slaves
+	node := p.nodes[nodeName]
+	node.mu.Lock()
+	node.slaves = slaves
+	node.definition.slaves = slaves
+	node.mu.Unlock()
+	p.startHealthCheck()
 }
 
 // 获取某个连接池
 func (p *Pool) GetNode(nodeName string) (node *Node, err error) {
 	var exist bool
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
 	if node, exist = p.nodes[nodeName]; !exist {
 		err = ErrNodeNotFound
 		return
@@ -236,37 +890,221 @@ func (p *Pool) GetNode(nodeName string) (node *Node, err error) {
 	return
 }
 
-// Init 初始化redis配置
+// 获取所有节点的名称
+func (p *Pool) NodeNames() (names []string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 
-func Init(redisConfig Config, nodeConfig ini.File, forceInit ...bool) {
-	var (
-		findDefaultNode = false
-		nodeSlaveMap    = make(map[string][]string)
-		nodeSchemeMap   = make(map[string]string)
+	names = make([]string, 0, len(p.nodes))
+	for name := range p.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
 
-		exist  bool
-		scheme string
-		slaves string
-	)
+// 获取没有作为其他节点slave的节点名称
+func (p *Pool) masterNodeNames() (names []string) {
+	p.mu.RLock()
+	slaves := make(map[string]bool)
+	for name, node := range p.nodes {
+		for _, slave := range node.slaveNames() {
+			if slave != name {
+				slaves[slave] = true
+			}
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, name := range p.NodeNames() {
+		if !slaves[name] {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+// 移除并关闭所有节点的连接池
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopHealthCheck()
+	for name, node := range p.nodes {
+		delete(p.nodes, name)
+		node.resources().close()
+	}
+}
+
+// 新增节点，见Pool.AddNode
+func AddNode(nodeName, scheme string, nodeConfig ...Config) error {
+	return pool.AddNode(nodeName, scheme, nodeConfig...)
+}
+
+// 替换节点，见Pool.ReplaceNode
+func ReplaceNode(nodeName, scheme string, nodeConfig ...Config) error {
+	return pool.ReplaceNode(nodeName, scheme, nodeConfig...)
+}
+
+// 移除节点，见Pool.RemoveNode
+func RemoveNode(nodeName string) error {
+	return pool.RemoveNode(nodeName)
+}
+
+// Init 初始化redis配置，加载节点后确保每个节点都加载了通过RegisterFunctionLibrary注册的函数库
 
+func Init(redisConfig Config, nodeConfig ini.File, forceInit ...bool) {
 	if len(forceInit) == 0 {
 		forceInit = make([]bool, 1)
 		forceInit[0] = false
 	}
+	initMu.Lock()
+	defer initMu.Unlock()
 	if isInit && !forceInit[0] {
 		return
 	}
 
-	config.Set(redisConfig)
+	pool.SetConfig(redisConfig)
+	pool.LoadNodes(nodeConfig)
+	if err := pool.EnsureFunctionLibraries(context.Background()); err != nil {
+		fmt.Println("[warning][redis]", err.Error())
+	}
+	isInit = true
+}
+
+// 从ini配置中加载所有redis_node_开头的节点到p中，已经存在的同名节点会被替换
+func (p *Pool) LoadNodes(nodeConfig ini.File) {
+	definitions := parseNodeDefinitions(nodeConfig, p.Config())
+	if _, exist := definitions[DefaultNodeName]; !exist {
+		fmt.Println("[warning][redis] not set default node")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for nodeName, definition := range definitions {
+		p.setNode(nodeName, definition)
+		p.nodes[nodeName].setRouting(definition)
+	}
+}
+
+// ini中解析出的节点定义
+type nodeDefinition struct {
+	// 节点模式，NodeModeStandalone或NodeModeCluster
+	mode   string
+	scheme string
+	// 集群模式下的种子节点地址(host:port)
+	seeds []string
+	// sentinel模式下的sentinel地址(host:port)，master名称，sentinel的密码和数据库
+	sentinels        []string
+	masterName       string
+	sentinelPassword string
+	db               int
+	// 集群和sentinel模式下连接数据节点的密码
+	password string
+	slaves   []string
+	// 选择slave的策略和作为slave时的权重
+	selector string
+	weight   int
+	config   Config
+}
+
+// 判断两个节点定义的slave设置是否相同
+func (d nodeDefinition) sameRouting(o nodeDefinition) bool {
+	return equalStrings(d.slaves, o.slaves) && d.selector == o.selector && d.weight == o.weight
+}
+
+// 设置节点的slaves，slave选择策略和权重
+func (n *Node) setRouting(definition nodeDefinition) {
+	selector, err := newSlaveSelector(definition.selector)
+	if err != nil {
+		fmt.Println("[warning][redis]", err.Error())
+		selector = RandomSelector{}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.slaves = definition.slaves
+	n.selector = selector
+	n.definition.slaves = definition.slaves
+	n.definition.selector = definition.selector
+	n.definition.weight = definition.weight
+}
+
+// 判断两个节点定义是否使用相同的连接资源，slaves不参与比较
+func (d nodeDefinition) samePool(o nodeDefinition) bool {
+	return d.mode == o.mode &&
+		d.scheme == o.scheme &&
+		d.password == o.password &&
+		equalStrings(d.seeds, o.seeds) &&
+		equalStrings(d.sentinels, o.sentinels) &&
+		d.masterName == o.masterName &&
+		d.sentinelPassword == o.sentinelPassword &&
+		d.db == o.db &&
+		d.config == o.config
+}
+
+// 从ini配置中解析出所有redis_node_开头的节点定义，base为节点没有单独配置时使用的连接池配置
+func parseNodeDefinitions(nodeConfig ini.File, base Config) map[string]nodeDefinition {
+	var (
+		definitions  = make(map[string]nodeDefinition)
+		nodeSlaveMap = make(map[string][]string)
+
+		exist  bool
+		scheme string
+		slaves string
+		err    error
+	)
 
 	// 遍历出相关的node名称和slave
 	for sectionName, section := range nodeConfig {
 		if strings.HasPrefix(sectionName, NodeConfigPrefix) {
 			nodeName := strings.TrimPrefix(sectionName, NodeConfigPrefix)
-			scheme, exist = section["scheme"]
-			if exist {
-				nodeSchemeMap[nodeName] = scheme
+			definition := nodeDefinition{
+				mode:     strings.ToLower(strings.TrimSpace(section["mode"])),
+				password: section["password"],
+				selector: strings.ToLower(strings.TrimSpace(section["slave_selector"])),
+				config:   parseNodeConfig(nodeName, base, section),
+			}
+			if weight, exist := section["weight"]; exist {
+				if definition.weight, err = strconv.Atoi(strings.TrimSpace(weight)); err != nil || definition.weight < 0 {
+					fmt.Printf("[warning][redis] node %s has invalid weight: %s\n", nodeName, weight)
+					definition.weight = 0
+				}
+			}
+			if _, exist = section["sentinels"]; exist && definition.mode == "" {
+				definition.mode = NodeModeSentinel
 			}
+			switch definition.mode {
+			case NodeModeSentinel:
+				definition.sentinels = splitList(section["sentinels"])
+				definition.masterName = strings.TrimSpace(section["master_name"])
+				definition.sentinelPassword = section["sentinel_password"]
+				if len(definition.sentinels) == 0 || definition.masterName == "" {
+					fmt.Printf("[warning][redis] sentinel node %s requires sentinels and master_name\n", nodeName)
+					continue
+				}
+				if db, exist := section["db"]; exist {
+					if definition.db, err = strconv.Atoi(strings.TrimSpace(db)); err != nil {
+						fmt.Printf("[warning][redis] node %s has invalid db: %s\n", nodeName, db)
+						continue
+					}
+				}
+			case NodeModeCluster:
+				definition.seeds = splitList(section["seeds"])
+				if len(definition.seeds) == 0 {
+					fmt.Printf("[warning][redis] cluster node %s has no seeds\n", nodeName)
+					continue
+				}
+			case "", NodeModeStandalone:
+				definition.mode = NodeModeStandalone
+				if scheme, exist = section["scheme"]; !exist {
+					continue
+				}
+				definition.scheme = scheme
+			default:
+				fmt.Printf("[warning][redis] node %s has invalid mode: %s\n", nodeName, definition.mode)
+				continue
+			}
+			definitions[nodeName] = definition
 			slaves, exist = section["slaves"]
 			if exist {
 				nodeSlaveMap[nodeName] = strings.Split(slaves, ",")
@@ -274,25 +1112,106 @@ func Init(redisConfig Config, nodeConfig ini.File, forceInit ...bool) {
 		}
 	}
 	// 过滤掉那些没有dsn的slave的node
-	for k, v := range nodeSlaveMap {
-		filterSlaves := make([]string, 0, len(v))
-		for _, vv := range v {
-			if _, exist = nodeSchemeMap[vv]; exist {
-				filterSlaves = append(filterSlaves, vv)
+	for nodeName, definition := range definitions {
+		definition.slaves = make([]string, 0, len(nodeSlaveMap[nodeName]))
+		for _, slave := range nodeSlaveMap[nodeName] {
+			if _, exist = definitions[slave]; exist {
+				definition.slaves = append(definition.slaves, slave)
 			}
 		}
-		nodeSlaveMap[k] = filterSlaves
+		definitions[nodeName] = definition
 	}
 
-	for nodeName, scheme := range nodeSchemeMap {
-		if nodeName == DefaultNodeName {
-			findDefaultNode = true
+	return definitions
+}
+
+// 解析node在ini中单独配置的连接池参数，没有配置的参数沿用base中的值
+// 时间类的参数支持"30s"这种格式，纯数字时单位为秒
+func parseNodeConfig(nodeName string, base Config, section ini.Section) Config {
+	var (
+		intOptions = map[string]*int{
+			"max_idle":   &base.MaxIdle,
+			"max_active": &base.MaxActive,
+		}
+		durationOptions = map[string]*time.Duration{
+			"idle_timeout":      &base.IdleTimeOut,
+			"max_conn_lifetime": &base.MaxConnLifetime,
+			"timeout":           &base.Timeout,
+			"connect_timeout":   &base.ConnectTimeout,
+			"read_timeout":      &base.ReadTimeout,
+			"write_timeout":     &base.WriteTimeout,
+
+			"health_check_interval": &base.HealthCheckInterval,
+		}
+	)
+
+	for key, ptr := range intOptions {
+		if value, exist := section[key]; exist {
+			v, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || v < 0 {
+				fmt.Printf("[warning][redis] node %s has invalid %s: %s\n", nodeName, key, value)
+				continue
+			}
+			*ptr = v
 		}
-		pool.SetNode(nodeName, scheme)
-		pool.SetSlaves(nodeName, nodeSlaveMap[nodeName])
 	}
-	if !findDefaultNode {
-		fmt.Println("[warning][redis] not set default node")
+	for key, ptr := range durationOptions {
+		if value, exist := section[key]; exist {
+			v, err := parseDuration(value)
+			if err != nil || v < 0 {
+				fmt.Printf("[warning][redis] node %s has invalid %s: %s\n", nodeName, key, value)
+				continue
+			}
+			*ptr = v
+		}
 	}
-	isInit = true
+	if value, exist := section["max_replication_lag"]; exist {
+		v, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil || v < 0 {
+			fmt.Printf("[warning][redis] node %s has invalid max_replication_lag: %s\n", nodeName, value)
+		} else {
+			base.MaxReplicationLag = v
+		}
+	}
+	if value, exist := section["protocol"]; exist {
+		v, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || (v != ProtocolRESP2 && v != ProtocolRESP3) {
+			fmt.Printf("[warning][redis] node %s has invalid protocol: %s\n", nodeName, value)
+		} else {
+			base.Protocol = v
+		}
+	}
+	if value, exist := section["client_name"]; exist {
+		base.ClientName = strings.TrimSpace(value)
+	}
+	if value, exist := section["wait"]; exist {
+		v, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			fmt.Printf("[warning][redis] node %s has invalid wait: %s\n", nodeName, value)
+		} else {
+			base.Wait = v
+		}
+	}
+
+	return base
+}
+
+// 解析时间配置，纯数字时单位为秒
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// 解析逗号分隔的列表，忽略空白项
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
@@ -1,9 +1,14 @@
 package goredis
 
 import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
 	"github.com/vaughan0/go-ini"
 	"testing"
 	"github.com/gomodule/redigo/redis"
+	"time"
 )
 
 func TestInit(t *testing.T) {
@@ -67,3 +72,266 @@ func TestInit(t *testing.T) {
 	t.Log("active:\t", node.GetPool().ActiveCount())
 	t.Log("idle:\t", node.GetPool().IdleCount())
 }
+
+func TestCommandContext(t *testing.T) {
+	testInit()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := CommandContext(ctx, DefaultNodeName, "SET", "name", "scofield"); err != nil {
+		t.Error("set command fail! error: ", err.Error())
+		return
+	}
+	name, err := redis.String(CommandContext(ctx, DefaultNodeName, "GET", "name"))
+	if err != nil {
+		t.Error("get command fail!error:", err.Error())
+	} else if name != "scofield" {
+		t.Error("get wrong result,want scofield,get ", name)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err = CommandContext(ctx, DefaultNodeName, "GET", "name"); err != context.Canceled {
+		t.Error("want context.Canceled,get ", err)
+	}
+}
+
+func TestCommandContextBlocking(t *testing.T) {
+	p := NewPool(Config{MaxActive: 2, Wait: true})
+	defer p.Close()
+	p.SetNode(DefaultNodeName, "redis://:@localhost:6379")
+	node, err := p.GetNode(DefaultNodeName)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	node.Command("DEL", "list_context")
+
+	// ctx被取消时中断阻塞的命令，连接立即释放
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := node.CommandContext(ctx, "BLPOP", "list_context", 0)
+			errs <- err
+		}()
+	}
+	time.Sleep(time.Millisecond * 100)
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err = <-errs; err != context.Canceled {
+			t.Error("want context.Canceled,get ", err)
+		}
+	}
+	if active := node.GetPool().ActiveCount(); active != 0 {
+		t.Error("want no active connection,get ", active)
+	}
+
+	// ctx超时时返回context.DeadlineExceeded而不是读超时的网络错误
+	for i := 0; i < 2; i++ {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+		if _, err = node.CommandContext(ctx, "BLPOP", "list_context", 0); !errors.Is(err, context.DeadlineExceeded) {
+			t.Error("want context.DeadlineExceeded,get ", err)
+		}
+		cancel()
+	}
+	if active := node.GetPool().ActiveCount(); active != 0 {
+		t.Error("want no active connection,get ", active)
+	}
+}
+
+func TestGetConnContextNoResponse(t *testing.T) {
+	// 只接受连接但从不回复的服务端
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	for _, protocol := range []int{ProtocolRESP2, ProtocolRESP3} {
+		p := NewPool(Config{Protocol: protocol, ReadTimeout: time.Second * 10})
+		p.SetNode(DefaultNodeName, "redis://"+ln.Addr().String())
+		node, _ := p.GetNode(DefaultNodeName)
+		if protocol == ProtocolRESP2 {
+			// RESP2建立连接时不发送命令，连接放回连接池后在TestOnBorrow的PING上阻塞
+			conn, err := node.GetConnContext(context.Background())
+			if err != nil {
+				t.Error(err.Error())
+				p.Close()
+				continue
+			}
+			conn.Close()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		start := time.Now()
+		if _, err = node.GetConnContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Error(protocol, " want context.DeadlineExceeded,get ", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Error(protocol, " want get bounded by ctx deadline, elapsed ", elapsed)
+		}
+		cancel()
+		p.Close()
+	}
+}
+
+func TestNodeConfig(t *testing.T) {
+	Init(Config{MaxActive: 10, Wait: true}, ini.File{
+		"redis_node_default": ini.Section{
+			"scheme": "redis://:@localhost:6379",
+		},
+		"redis_node_cache": ini.Section{
+			"scheme":            "redis://:@localhost:6379",
+			"max_active":        "50",
+			"idle_timeout":      "30s",
+			"max_conn_lifetime": "600",
+			"wait":              "false",
+		},
+	}, true)
+
+	node, err := GetNode()
+	if err != nil {
+		t.Error("get node fail,err:", err.Error())
+		return
+	}
+	if p := node.GetPool(); p.MaxActive != 10 || !p.Wait || p.IdleTimeout != time.Second*DefaultIdleTimeout {
+		t.Errorf("default node pool config wrong,get %d %v %v", p.MaxActive, p.Wait, p.IdleTimeout)
+	}
+
+	node, err = GetNode("cache")
+	if err != nil {
+		t.Error("get node fail,err:", err.Error())
+		return
+	}
+	p := node.GetPool()
+	if p.MaxActive != 50 || p.Wait || p.IdleTimeout != time.Second*30 || p.MaxConnLifetime != time.Minute*10 {
+		t.Errorf("cache node pool config wrong,get %d %v %v %v", p.MaxActive, p.Wait, p.IdleTimeout, p.MaxConnLifetime)
+	}
+	if _, err = node.Command("PING"); err != nil {
+		t.Error("ping fail,err:", err.Error())
+	}
+}
+
+func TestNodeRegistry(t *testing.T) {
+	testInit()
+	scheme := "redis://:@localhost:6379"
+	if err := AddNode("registry", scheme); err != nil {
+		t.Error("add node fail,err:", err.Error())
+		return
+	}
+	if err := AddNode("registry", scheme); err != ErrNodeExists {
+		t.Error("want ErrNodeExists,get ", err)
+	}
+	node, err := GetNode("registry")
+	if err != nil {
+		t.Error("get node fail,err:", err.Error())
+		return
+	}
+	oldPool := node.GetPool()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if _, err := Command("registry", "PING"); err != nil {
+				t.Error("ping fail,err:", err.Error())
+				return
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		if err = ReplaceNode("registry", scheme, Config{MaxIdle: 1, MaxActive: 5, Wait: true}); err != nil {
+			t.Error("replace node fail,err:", err.Error())
+		}
+	}
+	<-done
+
+	if node.GetPool() == oldPool || node.GetPool().MaxActive != 5 {
+		t.Error("node pool not replaced")
+	}
+	if err = RemoveNode("registry"); err != nil {
+		t.Error("remove node fail,err:", err.Error())
+	}
+	if _, err = GetNode("registry"); err != ErrNodeNotFound {
+		t.Error("want ErrNodeNotFound,get ", err)
+	}
+	if err = RemoveNode("registry"); err != ErrNodeNotFound {
+		t.Error("want ErrNodeNotFound,get ", err)
+	}
+	if err = ReplaceNode("registry", scheme); err != ErrNodeNotFound {
+		t.Error("want ErrNodeNotFound,get ", err)
+	}
+}
+
+func TestNewPool(t *testing.T) {
+	p1 := NewPool(Config{MaxActive: 3, Wait: true})
+	defer p1.Close()
+	p1.LoadNodes(ini.File{
+		"redis_node_default": ini.Section{
+			"scheme": "redis://:@localhost:6379",
+			"slaves": "slave1",
+		},
+		"redis_node_slave1": ini.Section{
+			"scheme": "redis://:@localhost:6379",
+		},
+	})
+	p2 := NewPool(Config{})
+	defer p2.Close()
+
+	if _, err := p2.GetNode(DefaultNodeName); err != ErrNodeNotFound {
+		t.Error("want ErrNodeNotFound,get ", err)
+	}
+	node, err := p1.GetNode(DefaultNodeName)
+	if err != nil {
+		t.Error("get node fail,err:", err.Error())
+		return
+	}
+	if node.GetPool().MaxActive != 3 {
+		t.Error("want max active 3,get ", node.GetPool().MaxActive)
+	}
+	if err = p1.NewHelper().Set("name", "scofield"); err != nil {
+		t.Error("set fail,err:", err.Error())
+		return
+	}
+	name, err := redis.String(p1.CommandOnSlave(DefaultNodeName, "GET", "name"))
+	if err != nil {
+		t.Error("get slave command fail! error:", err.Error())
+	} else if name != "scofield" {
+		t.Error("get wrong result,want scofield,get ", name)
+	}
+	if _, err = p2.NewHelper().Get("name"); err != ErrNodeNotFound {
+		t.Error("want ErrNodeNotFound,get ", err)
+	}
+}
+
+func TestConnDoWriteDeadline(t *testing.T) {
+	// 对端不读取数据时写入会一直阻塞，ctx的deadline应当在连接的写超时之前生效
+	client, server := net.Pipe()
+	defer server.Close()
+	nc := &deadlineNetConn{Conn: client}
+	conns := map[string]redis.Conn{
+		"resp2": deadlineConn{Conn: redis.NewConn(nc, time.Second*10, time.Second*10), nc: nc},
+		"resp3": &resp3Conn{conn: client, readTimeout: time.Second * 10, writeTimeout: time.Second * 10, br: bufio.NewReader(client), bw: bufio.NewWriter(client)},
+	}
+	for name, conn := range conns {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		start := time.Now()
+		if _, err := connDo(ctx, conn, "SET", "name", "scofield"); err == nil {
+			t.Error(name, " want write timeout error")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Error(name, " want write bounded by ctx deadline, elapsed ", elapsed)
+		}
+		cancel()
+	}
+}
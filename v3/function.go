@@ -0,0 +1,292 @@
+package goredis
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"strings"
+)
+
+const (
+	// FUNCTION RESTORE的策略：追加，库已经存在时报错
+	FunctionRestoreAppend = "APPEND"
+	// FUNCTION RESTORE的策略：先删除所有的库
+	FunctionRestoreFlush = "FLUSH"
+	// FUNCTION RESTORE的策略：替换已经存在的库
+	FunctionRestoreReplace = "REPLACE"
+)
+
+// FUNCTION LIST返回的函数库
+type FunctionLibrary struct {
+	Name      string
+	Engine    string
+	Functions []FunctionInfo
+	// 只有FunctionList的withCode为true时才有值
+	Code string
+}
+
+// 函数库中的函数
+type FunctionInfo struct {
+	Name        string
+	Description string
+	Flags       []string
+}
+
+// 加载函数库，replace为true时替换已经存在的同名库，返回库的名称，需要redis 7.0以上
+// 函数会被redis复制到replica上，集群节点会加载到每个master分片
+func (h *Helper) FunctionLoad(code string, replace bool) (library string, err error) {
+	args := redis.Args{}.Add("LOAD")
+	if replace {
+		args = args.Add("REPLACE")
+	}
+	args = args.Add(code)
+
+	var node *Node
+	if node, err = h.pool.GetNode(h.nodeName); err != nil {
+		return
+	}
+	err = node.eachMaster(h.Context(), func(conn redis.Conn) (err error) {
+		library, err = redis.String(connDo(h.Context(), conn, "FUNCTION", args...))
+		return
+	})
+	return
+}
+
+// 获取函数库列表，pattern为空时返回所有库，withCode为true时同时返回库的代码
+// 集群节点返回其中一个master分片上的结果
+func (h *Helper) FunctionList(pattern string, withCode bool) (libraries []FunctionLibrary, err error) {
+	args := redis.Args{}.Add("LIST")
+	if pattern != "" {
+		args = args.Add("LIBRARYNAME", pattern)
+	}
+	if withCode {
+		args = args.Add("WITHCODE")
+	}
+	return parseFunctionList(h.command("FUNCTION", args...))
+}
+
+// 删除函数库，集群节点会在每个master分片上删除
+func (h *Helper) FunctionDelete(library string) (err error) {
+	var node *Node
+	if node, err = h.pool.GetNode(h.nodeName); err != nil {
+		return
+	}
+	return node.eachMaster(h.Context(), func(conn redis.Conn) error {
+		_, err := connDo(h.Context(), conn, "FUNCTION", "DELETE", library)
+		return err
+	})
+}
+
+// 导出所有函数库的序列化数据，用于FunctionRestore，集群节点导出其中一个master分片上的函数库
+func (h *Helper) FunctionDump() (payload []byte, err error) {
+	payload, err = redis.Bytes(h.command("FUNCTION", "DUMP"))
+	return
+}
+
+// 从FunctionDump导出的数据恢复函数库，policy为FunctionRestoreAppend等，为空时使用APPEND
+// 集群节点会恢复到每个master分片
+func (h *Helper) FunctionRestore(payload []byte, policy string) (err error) {
+	args := redis.Args{}.Add("RESTORE", payload)
+	if policy != "" {
+		args = args.Add(policy)
+	}
+
+	var node *Node
+	if node, err = h.pool.GetNode(h.nodeName); err != nil {
+		return
+	}
+	return node.eachMaster(h.Context(), func(conn redis.Conn) error {
+		_, err := connDo(h.Context(), conn, "FUNCTION", args...)
+		return err
+	})
+}
+
+// 调用函数，集群节点会路由到第一个key所在的分片
+func (h *Helper) FCall(function string, keys []string, args ...interface{}) (reply interface{}, err error) {
+	return h.command("FCALL", fcallArgs(function, keys, args)...)
+}
+
+// 调用只读函数(使用no-writes标记注册的函数)，命令会通过节点的SlaveSelector从GetSlaves中选择一个健康的slave执行，
+// 所有slave都不健康时在master上执行，节点没有slave时直接在节点上执行
+func (h *Helper) FCallRO(function string, keys []string, args ...interface{}) (reply interface{}, err error) {
+	reply, err = h.pool.CommandOnSlaveContext(h.Context(), h.nodeName, "FCALL_RO", fcallArgs(function, keys, args)...)
+	if err != ErrNodeNotFound {
+		return resp2Reply(reply, err)
+	}
+	return resp2Reply(h.pool.CommandContext(h.Context(), h.nodeName, "FCALL_RO", fcallArgs(function, keys, args)...))
+}
+
+// 生成FCALL的参数
+func fcallArgs(function string, keys []string, args []interface{}) redis.Args {
+	return redis.Args{}.Add(function, len(keys)).AddFlat(keys).Add(args...)
+}
+
+// 注册函数库到默认Pool，见Pool.RegisterFunctionLibrary
+func RegisterFunctionLibrary(code string) error {
+	return pool.RegisterFunctionLibrary(code)
+}
+
+// 注册函数库，并确保Pool中已有的节点加载了该版本的库，见Node.EnsureFunctionLibrary
+// 在Init之前注册时，Init加载节点后会确保每个节点加载了已经注册的库；之后通过AddNode，Reload等方式新增的节点
+// 需要调用EnsureFunctionLibraries；同名的库重复注册时替换之前注册的代码
+func (p *Pool) RegisterFunctionLibrary(code string) error {
+	name, err := functionLibraryName(code)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	replaced := false
+	for i, registered := range p.libraries {
+		if registeredName, _ := functionLibraryName(registered); registeredName == name {
+			p.libraries[i], replaced = code, true
+			break
+		}
+	}
+	if !replaced {
+		p.libraries = append(p.libraries, code)
+	}
+	p.mu.Unlock()
+	return p.EnsureFunctionLibraries(context.Background(), code)
+}
+
+// 确保Pool中的每个节点都加载了libraries，没有指定libraries时使用所有已经注册的库
+// 作为其他节点slave的节点会被跳过，函数库由master复制到replica上
+// 返回最后一个出错的错误，出错时其他节点仍然会继续加载
+func (p *Pool) EnsureFunctionLibraries(ctx context.Context, libraries ...string) (err error) {
+	if len(libraries) == 0 {
+		p.mu.RLock()
+		libraries = append([]string(nil), p.libraries...)
+		p.mu.RUnlock()
+	}
+	if len(libraries) == 0 {
+		return
+	}
+	for _, nodeName := range p.masterNodeNames() {
+		node, nodeErr := p.GetNode(nodeName)
+		if nodeErr != nil {
+			continue
+		}
+		for _, code := range libraries {
+			if ensureErr := node.EnsureFunctionLibrary(ctx, code); ensureErr != nil {
+				err = fmt.Errorf("redis: ensure function library on node %s fail: %s", nodeName, ensureErr.Error())
+			}
+		}
+	}
+	return
+}
+
+// 确保节点的每个master上加载了code对应的库：库不存在或者已加载的代码与code不一致(如旧版本)时使用REPLACE加载
+// 库的名称从代码第一行的"#!lua name=<library>"中获取
+func (n *Node) EnsureFunctionLibrary(ctx context.Context, code string) error {
+	name, err := functionLibraryName(code)
+	if err != nil {
+		return err
+	}
+	return n.eachMaster(ctx, func(conn redis.Conn) error {
+		libraries, err := parseFunctionList(resp2Reply(connDo(ctx, conn, "FUNCTION", "LIST", "LIBRARYNAME", name, "WITHCODE")))
+		if err != nil {
+			return err
+		}
+		for _, library := range libraries {
+			if library.Name == name && library.Code == code {
+				return nil
+			}
+		}
+		_, err = connDo(ctx, conn, "FUNCTION", "LOAD", "REPLACE", code)
+		return err
+	})
+}
+
+// 从函数库代码的第一行获取库的名称
+func functionLibraryName(code string) (string, error) {
+	line := code
+	if i := strings.IndexByte(code, '\n'); i >= 0 {
+		line = code[:i]
+	}
+	if strings.HasPrefix(line, "#!") {
+		for _, field := range strings.Fields(line)[1:] {
+			if strings.HasPrefix(field, "name=") && len(field) > len("name=") {
+				return field[len("name="):], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("redis: function library name not found in %q", line)
+}
+
+// 解析FUNCTION LIST的结果
+func parseFunctionList(reply interface{}, err error) ([]FunctionLibrary, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	libraries := make([]FunctionLibrary, 0, len(values))
+	for _, value := range values {
+		var library FunctionLibrary
+		err = eachPair(value, func(key string, value interface{}) (err error) {
+			switch key {
+			case "library_name":
+				library.Name, err = redis.String(value, nil)
+			case "engine":
+				library.Engine, err = redis.String(value, nil)
+			case "library_code":
+				library.Code, err = redis.String(value, nil)
+			case "functions":
+				library.Functions, err = parseFunctionInfos(value)
+			}
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+		libraries = append(libraries, library)
+	}
+	return libraries, nil
+}
+
+// 解析函数库中的函数列表
+func parseFunctionInfos(reply interface{}) (functions []FunctionInfo, err error) {
+	var values []interface{}
+	if values, err = redis.Values(reply, nil); err != nil {
+		return
+	}
+	functions = make([]FunctionInfo, 0, len(values))
+	for _, value := range values {
+		var function FunctionInfo
+		err = eachPair(value, func(key string, value interface{}) (err error) {
+			switch key {
+			case "name":
+				function.Name, err = redis.String(value, nil)
+			case "description":
+				if value != nil {
+					function.Description, err = redis.String(value, nil)
+				}
+			case "flags":
+				function.Flags, err = redis.Strings(value, nil)
+			}
+			return
+		})
+		if err != nil {
+			return nil, err
+		}
+		functions = append(functions, function)
+	}
+	return
+}
+
+// 遍历redis返回的key-value数组
+func eachPair(reply interface{}, fn func(key string, value interface{}) error) error {
+	values, err := redis.Values(reply, nil)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(values); i += 2 {
+		key, err := redis.String(values[i], nil)
+		if err != nil {
+			return err
+		}
+		if err = fn(key, values[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
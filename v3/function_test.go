@@ -0,0 +1,140 @@
+package goredis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"strings"
+	"testing"
+)
+
+const testFunctionLibrary = "#!lua name=goredis_test\n" +
+	"redis.register_function('goredis_incr', function(keys, args) return redis.call('incrby', keys[1], args[1]) end)\n" +
+	"redis.register_function{function_name='goredis_get', callback=function(keys) return redis.call('get', keys[1]) end, flags={'no-writes'}}"
+
+// redis 7.0以下或者不支持FUNCTION命令的服务端跳过测试
+func skipWithoutFunctions(t *testing.T, helper *Helper) {
+	if _, err := helper.FunctionList("", false); err != nil && strings.Contains(err.Error(), "unknown command") {
+		t.Skip("FUNCTION not supported on localhost:6379")
+	}
+}
+
+func TestFunctionLibraryName(t *testing.T) {
+	if name, err := functionLibraryName(testFunctionLibrary); err != nil || name != "goredis_test" {
+		t.Error("want goredis_test, get ", name, err)
+	}
+	if _, err := functionLibraryName("redis.register_function('f', function() return 1 end)"); err == nil {
+		t.Error("want error without library name")
+	}
+}
+
+func TestRegisterFunctionLibrary(t *testing.T) {
+	p := NewPool(Config{})
+	defer p.Close()
+	p.RegisterFunctionLibrary(testFunctionLibrary)
+	updated := strings.Replace(testFunctionLibrary, "incrby", "incr", 1)
+	if err := p.RegisterFunctionLibrary(updated); err != nil {
+		t.Error(err.Error())
+	}
+	// 同名的库只保留最后注册的代码
+	if len(p.libraries) != 1 || p.libraries[0] != updated {
+		t.Error("want library replaced, get ", p.libraries)
+	}
+}
+
+func TestMasterNodeNames(t *testing.T) {
+	p := NewPool(Config{})
+	defer p.Close()
+	p.SetNode("master", "redis://127.0.0.1:6379")
+	p.SetNode("replica", "redis://127.0.0.1:6379")
+	p.SetNode("other", "redis://127.0.0.1:6379")
+	p.SetSlaves("master", []string{"replica"})
+	// 作为slave的节点不加载函数库
+	if names := p.masterNodeNames(); len(names) != 2 || names[0] != "master" || names[1] != "other" {
+		t.Error("want [master other], get ", names)
+	}
+}
+
+func TestParseFunctionList(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{
+			[]byte("library_name"), []byte("mylib"),
+			[]byte("engine"), []byte("LUA"),
+			[]byte("functions"), []interface{}{
+				[]interface{}{[]byte("name"), []byte("f"), []byte("description"), nil, []byte("flags"), []interface{}{[]byte("no-writes")}},
+			},
+			[]byte("library_code"), []byte("code"),
+		},
+	}
+	libraries, err := parseFunctionList(reply, nil)
+	if err != nil || len(libraries) != 1 {
+		t.Error("want 1 library, get ", libraries, err)
+		return
+	}
+	library := libraries[0]
+	if library.Name != "mylib" || library.Engine != "LUA" || library.Code != "code" || len(library.Functions) != 1 {
+		t.Error("wrong library ", library)
+		return
+	}
+	if f := library.Functions[0]; f.Name != "f" || f.Description != "" || len(f.Flags) != 1 || f.Flags[0] != "no-writes" {
+		t.Error("wrong function ", f)
+	}
+}
+
+func TestFunction(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	skipWithoutFunctions(t, helper)
+	helper.FunctionDelete("goredis_test")
+	helper.Del("function_counter")
+
+	if library, err := helper.FunctionLoad(testFunctionLibrary, false); err != nil || library != "goredis_test" {
+		t.Error("want goredis_test, get ", library, err)
+		return
+	}
+	if _, err := helper.FunctionLoad(testFunctionLibrary, false); err == nil {
+		t.Error("want error when library exists")
+	}
+	libraries, err := helper.FunctionList("goredis_test", true)
+	if err != nil || len(libraries) != 1 || libraries[0].Code != testFunctionLibrary || len(libraries[0].Functions) != 2 {
+		t.Error("wrong libraries ", libraries, err)
+	}
+
+	if n, err := redis.Int64(helper.FCall("goredis_incr", []string{"function_counter"}, 2)); err != nil || n != 2 {
+		t.Error("want 2, get ", n, err)
+	}
+	if n, err := redis.Int64(helper.FCallRO("goredis_get", []string{"function_counter"})); err != nil || n != 2 {
+		t.Error("want 2, get ", n, err)
+	}
+
+	payload, err := helper.FunctionDump()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if err = helper.FunctionDelete("goredis_test"); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err = helper.FCall("goredis_incr", []string{"function_counter"}, 1); err == nil {
+		t.Error("want error after library deleted")
+	}
+	if err = helper.FunctionRestore(payload, FunctionRestoreReplace); err != nil {
+		t.Error(err.Error())
+	}
+	if n, err := redis.Int64(helper.FCall("goredis_incr", []string{"function_counter"}, 1)); err != nil || n != 3 {
+		t.Error("want 3, get ", n, err)
+	}
+
+	// 已经加载的代码不一致时替换
+	helper.FunctionDelete("goredis_test")
+	helper.FunctionLoad("#!lua name=goredis_test\nredis.register_function('goredis_old', function() return 1 end)", false)
+	p := NewPool(Config{})
+	defer p.Close()
+	p.SetNode(DefaultNodeName, "redis://127.0.0.1:6379")
+	if err = p.RegisterFunctionLibrary(testFunctionLibrary); err != nil {
+		t.Error(err.Error())
+	}
+	if n, err := redis.Int64(helper.FCall("goredis_incr", []string{"function_counter"}, 1)); err != nil || n != 4 {
+		t.Error("want 4, get ", n, err)
+	}
+	helper.FunctionDelete("goredis_test")
+	helper.Del("function_counter")
+}
@@ -0,0 +1,238 @@
+package goredis
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// HGetStruct的参数不是指向struct的非nil指针
+	ErrInvalidStruct = errors.New("redis: destination must be a non-nil pointer to struct")
+
+	// struct类型对应的hash字段，key为reflect.Type
+	structFieldsCache sync.Map
+)
+
+// struct中映射到hash的字段
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// 将struct写入hash，使用`redis:"field,omitempty"`标签指定field名称，没有标签时使用字段名，"-"表示忽略
+// string、[]byte、数字和bool直接写入，实现了encoding.TextMarshaler的类型(如time.Time)使用其文本形式，
+// 其他类型(struct、map、slice等)使用JSON；nil指针和设置了omitempty的零值字段不会被写入
+func (h *Helper) HSetStruct(key string, v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return ErrInvalidStruct
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return ErrInvalidStruct
+	}
+
+	args := redis.Args{}.Add(key)
+	for _, field := range getStructFields(rv.Type()) {
+		fv := rv.FieldByIndex(field.index)
+		if field.omitEmpty && isZeroValue(fv) {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		var value interface{}
+		if value, err = encodeField(fv); err != nil {
+			return fmt.Errorf("redis: encode field %s fail: %s", field.name, err.Error())
+		}
+		args = args.Add(field.name, value)
+	}
+	if len(args) == 1 {
+		return
+	}
+	_, err = h.command("HMSET", args...)
+	return
+}
+
+// 从hash中读取struct中定义的字段(使用HMGET，只读取需要的字段)，字段映射规则见HSetStruct
+// hash中不存在的字段保持原值不变，所有字段都不存在时返回ErrNil
+func (h *Helper) HGetStruct(key string, v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidStruct
+	}
+	rv = rv.Elem()
+
+	fields := getStructFields(rv.Type())
+	if len(fields) == 0 {
+		return
+	}
+	args := redis.Args{}.Add(key)
+	for _, field := range fields {
+		args = args.Add(field.name)
+	}
+	var replies []interface{}
+	if replies, err = redis.Values(h.command("HMGET", args...)); err != nil {
+		return
+	}
+
+	found := false
+	for i, reply := range replies {
+		if reply == nil || i >= len(fields) {
+			continue
+		}
+		found = true
+		var data []byte
+		if data, err = redis.Bytes(reply, nil); err != nil {
+			return
+		}
+		if err = decodeField(rv.FieldByIndex(fields[i].index), data); err != nil {
+			return fmt.Errorf("redis: decode field %s fail: %s", fields[i].name, err.Error())
+		}
+	}
+	if !found {
+		err = ErrNil
+	}
+	return
+}
+
+// 获取struct类型映射到hash的字段，匿名的struct字段会被展开
+func getStructFields(t reflect.Type) []structField {
+	if cached, ok := structFieldsCache.Load(t); ok {
+		return cached.([]structField)
+	}
+
+	fields := make([]structField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("redis")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			for _, embedded := range getStructFields(f.Type) {
+				embedded.index = append([]int{i}, embedded.index...)
+				fields = append(fields, embedded)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			// 未导出的字段
+			continue
+		}
+
+		field := structField{name: f.Name, index: []int{i}}
+		if tag != "" {
+			options := strings.Split(tag, ",")
+			if options[0] != "" {
+				field.name = options[0]
+			}
+			for _, option := range options[1:] {
+				if option == "omitempty" {
+					field.omitEmpty = true
+				}
+			}
+		}
+		fields = append(fields, field)
+	}
+
+	structFieldsCache.Store(t, fields)
+	return fields
+}
+
+// 判断字段是否为零值
+func isZeroValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// 将字段转换为写入redis的值
+func encodeField(v reflect.Value) (interface{}, error) {
+	if v.Type().Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return text, err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return v.Bytes(), nil
+		}
+	}
+	return json.Marshal(v.Interface())
+}
+
+// 将redis返回的值写入字段，指针字段会分配新的值
+func decodeField(v reflect.Value, data []byte) (err error) {
+	if v.Kind() == reflect.Ptr {
+		elem := reflect.New(v.Type().Elem())
+		if err = decodeField(elem.Elem(), data); err != nil {
+			return
+		}
+		v.Set(elem)
+		return
+	}
+	if unmarshaler, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return unmarshaler.UnmarshalText(data)
+	}
+
+	s := string(data)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		var b bool
+		if b, err = strconv.ParseBool(s); err == nil {
+			v.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		if n, err = strconv.ParseInt(s, 10, v.Type().Bits()); err == nil {
+			v.SetInt(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		if n, err = strconv.ParseUint(s, 10, v.Type().Bits()); err == nil {
+			v.SetUint(n)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, err = strconv.ParseFloat(s, v.Type().Bits()); err == nil {
+			v.SetFloat(f)
+		}
+	default:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes(append([]byte(nil), data...))
+			return
+		}
+		err = json.Unmarshal(data, v.Addr().Interface())
+	}
+	return
+}
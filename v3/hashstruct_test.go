@@ -0,0 +1,100 @@
+package goredis
+
+import (
+	"testing"
+	"time"
+)
+
+type testAddress struct {
+	City string `json:"city"`
+	Zip  string `json:"zip"`
+}
+
+type testBase struct {
+	ID int64 `redis:"id"`
+}
+
+type testUser struct {
+	testBase
+	Name      string            `redis:"name"`
+	Age       int               `redis:"age,omitempty"`
+	Score     float64           `redis:"score"`
+	Active    bool              `redis:"active"`
+	Nickname  *string           `redis:"nickname"`
+	CreatedAt time.Time         `redis:"created_at"`
+	Address   testAddress       `redis:"address"`
+	Tags      []string          `redis:"tags,omitempty"`
+	Extra     map[string]string `redis:"extra,omitempty"`
+	Avatar    []byte            `redis:"avatar"`
+	Ignored   string            `redis:"-"`
+	private   string
+}
+
+func TestHashStruct(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("hash_struct")
+	defer helper.Del("hash_struct")
+
+	nickname := "scof"
+	user := testUser{
+		testBase:  testBase{ID: 7},
+		Name:      "scofield",
+		Score:     99.5,
+		Active:    true,
+		Nickname:  &nickname,
+		CreatedAt: time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		Address:   testAddress{City: "Chengdu", Zip: "610000"},
+		Tags:      []string{"a", "b"},
+		Avatar:    []byte{1, 2, 3},
+		Ignored:   "ignored",
+		private:   "private",
+	}
+	if err := helper.HSetStruct("hash_struct", &user); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	// omitempty的零值字段和忽略的字段不会被写入
+	fields, _ := helper.HGetAllMap("hash_struct")
+	for _, name := range []string{"age", "extra", "Ignored", "private"} {
+		if _, exist := fields[name]; exist {
+			t.Error("want field ", name, " not written")
+		}
+	}
+	if fields["id"] != "7" || fields["address"] != `{"city":"Chengdu","zip":"610000"}` {
+		t.Error("unexpected hash fields ", fields)
+	}
+
+	var loaded testUser
+	loaded.Age = 18
+	if err := helper.HGetStruct("hash_struct", &loaded); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if loaded.ID != 7 || loaded.Name != "scofield" || loaded.Score != 99.5 || !loaded.Active ||
+		loaded.Nickname == nil || *loaded.Nickname != "scof" || !loaded.CreatedAt.Equal(user.CreatedAt) ||
+		loaded.Address != user.Address || len(loaded.Tags) != 2 || string(loaded.Avatar) != "\x01\x02\x03" {
+		t.Error("unexpected struct ", loaded)
+	}
+	// hash中不存在的字段保持原值
+	if loaded.Age != 18 || loaded.Ignored != "" {
+		t.Error("want fields not in hash unchanged, get ", loaded.Age, loaded.Ignored)
+	}
+
+	// 只读取struct中定义的字段
+	var partial struct {
+		Name    string `redis:"name"`
+		Missing string `redis:"missing"`
+	}
+	if err := helper.HGetStruct("hash_struct", &partial); err != nil || partial.Name != "scofield" {
+		t.Error("want scofield, get ", partial.Name, err)
+	}
+
+	if err := helper.HGetStruct("hash_struct_not_exist", &partial); err != ErrNil {
+		t.Error("want ErrNil, get ", err)
+	}
+	if err := helper.HGetStruct("hash_struct", partial); err != ErrInvalidStruct {
+		t.Error("want ErrInvalidStruct, get ", err)
+	}
+}
@@ -0,0 +1,341 @@
+package goredis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 随机选择slave
+	SelectorRandom = "random"
+	// 轮询选择slave
+	SelectorRoundRobin = "round_robin"
+	// 选择正在使用的连接数最少的slave
+	SelectorLeastActive = "least_active"
+	// 按照slave的weight加权随机选择
+	SelectorWeighted = "weighted"
+	// 选择健康检查延迟最低的slave
+	SelectorLatency = "latency"
+
+	// 健康检查的调度间隔，每个节点实际的检查间隔由Config.HealthCheckInterval决定
+	healthCheckTick = time.Second
+	// 计算延迟的指数移动平均时新样本的权重
+	latencyDecay = 0.3
+)
+
+var (
+	randMu sync.Mutex
+	// slave选择使用的随机数，避免每次选择都重新设置全局的随机数种子
+	slaveRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// 从可用的slave中选择一个节点，slaves不会为空
+type SlaveSelector interface {
+	Select(slaves []*Node) *Node
+}
+
+// 随机选择slave
+type RandomSelector struct{}
+
+func (RandomSelector) Select(slaves []*Node) *Node {
+	return slaves[randIntn(len(slaves))]
+}
+
+// 轮询选择slave
+type RoundRobinSelector struct {
+	next uint32
+}
+
+func (s *RoundRobinSelector) Select(slaves []*Node) *Node {
+	// 在uint32上取模，避免计数超过2^31后在32位平台上转换为负数
+	n := atomic.AddUint32(&s.next, 1) - 1
+	return slaves[int(n%uint32(len(slaves)))]
+}
+
+// 选择连接池中正在使用的连接数最少的slave
+type LeastActiveSelector struct{}
+
+func (LeastActiveSelector) Select(slaves []*Node) *Node {
+	best, bestActive := slaves[0], -1
+	for _, slave := range slaves {
+		p := slave.GetPool()
+		active := p.ActiveCount() - p.IdleCount()
+		if bestActive < 0 || active < bestActive {
+			best, bestActive = slave, active
+		}
+	}
+	return best
+}
+
+// 按照slave的weight加权随机选择，没有设置weight的slave权重为1
+type WeightedSelector struct{}
+
+func (WeightedSelector) Select(slaves []*Node) *Node {
+	total := 0
+	for _, slave := range slaves {
+		total += slave.Weight()
+	}
+	n := randIntn(total)
+	for _, slave := range slaves {
+		if n -= slave.Weight(); n < 0 {
+			return slave
+		}
+	}
+	return slaves[len(slaves)-1]
+}
+
+// 选择健康检查延迟最低的slave，还没有延迟数据的slave优先被选择
+type LatencySelector struct{}
+
+func (LatencySelector) Select(slaves []*Node) *Node {
+	best := slaves[0]
+	for _, slave := range slaves[1:] {
+		if slave.Latency() < best.Latency() {
+			best = slave
+		}
+	}
+	return best
+}
+
+// 根据名称创建slave选择策略
+func newSlaveSelector(name string) (SlaveSelector, error) {
+	switch name {
+	case "", SelectorRandom:
+		return RandomSelector{}, nil
+	case SelectorRoundRobin:
+		return &RoundRobinSelector{}, nil
+	case SelectorLeastActive:
+		return LeastActiveSelector{}, nil
+	case SelectorWeighted:
+		return WeightedSelector{}, nil
+	case SelectorLatency:
+		return LatencySelector{}, nil
+	}
+	return nil, fmt.Errorf("unknown slave selector %s", name)
+}
+
+// 并发安全的随机数
+func randIntn(n int) int {
+	randMu.Lock()
+	defer randMu.Unlock()
+	return slaveRand.Intn(n)
+}
+
+// 节点作为slave时的健康状态
+type nodeHealth struct {
+	// 延迟的指数移动平均值，单位纳秒
+	latency int64
+	// 与master的复制偏移量差值，单位字节
+	lag int64
+	// 健康检查失败时为1
+	unhealthy int32
+}
+
+// 判断节点是否健康，没有做过健康检查的节点视为健康
+func (n *Node) Healthy() bool {
+	return atomic.LoadInt32(&n.health.unhealthy) == 0
+}
+
+// 获取最近健康检查的延迟(指数移动平均值)，没有做过健康检查时为0
+func (n *Node) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&n.health.latency))
+}
+
+// 获取最近一次健康检查时与master的复制延迟(字节)
+func (n *Node) ReplicationLag() int64 {
+	return atomic.LoadInt64(&n.health.lag)
+}
+
+// 获取节点作为slave时的权重
+func (n *Node) Weight() int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.definition.weight > 0 {
+		return n.definition.weight
+	}
+	return 1
+}
+
+// 设置选择slave的策略
+func (n *Node) SetSlaveSelector(selector SlaveSelector) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.selector = selector
+}
+
+// 获取选择slave的策略，默认随机选择
+func (n *Node) getSlaveSelector() SlaveSelector {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.selector == nil {
+		return RandomSelector{}
+	}
+	return n.selector
+}
+
+// 记录一次健康检查的结果
+func (n *Node) setHealth(healthy bool, latency time.Duration, lag int64) {
+	if !healthy {
+		atomic.StoreInt32(&n.health.unhealthy, 1)
+		return
+	}
+	atomic.StoreInt32(&n.health.unhealthy, 0)
+	atomic.StoreInt64(&n.health.lag, lag)
+	if old := atomic.LoadInt64(&n.health.latency); old > 0 {
+		latency = time.Duration(float64(old)*(1-latencyDecay) + float64(latency)*latencyDecay)
+	}
+	atomic.StoreInt64(&n.health.latency, int64(latency))
+}
+
+// 启动p的健康检查，已经启动时直接返回，需要持有p.mu
+func (p *Pool) startHealthCheck() {
+	if p.healthStop != nil {
+		return
+	}
+	p.healthStop = make(chan struct{})
+	go p.healthCheckLoop(p.healthStop)
+}
+
+// 停止p的健康检查，需要持有p.mu
+func (p *Pool) stopHealthCheck() {
+	if p.healthStop != nil {
+		close(p.healthStop)
+		p.healthStop = nil
+	}
+}
+
+// 定时检查所有有slave的节点
+func (p *Pool) healthCheckLoop(stop chan struct{}) {
+	var (
+		mu       sync.Mutex
+		lastRun  = make(map[*Node]time.Time)
+		checking = make(map[*Node]bool)
+	)
+
+	ticker := time.NewTicker(healthCheckTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.RLock()
+		nodes := make([]*Node, 0, len(p.nodes))
+		exist := make(map[*Node]bool, len(p.nodes))
+		for _, node := range p.nodes {
+			nodes = append(nodes, node)
+			exist[node] = true
+		}
+		p.mu.RUnlock()
+
+		// 清理已经被移除的节点
+		mu.Lock()
+		for node := range lastRun {
+			if !exist[node] && !checking[node] {
+				delete(lastRun, node)
+			}
+		}
+		mu.Unlock()
+
+		now := time.Now()
+		for _, node := range nodes {
+			interval := node.GetConfig().HealthCheckInterval
+			mu.Lock()
+			skip := interval <= 0 || checking[node] || now.Sub(lastRun[node]) < interval
+			if !skip {
+				checking[node] = true
+				lastRun[node] = now
+			}
+			mu.Unlock()
+			if skip {
+				continue
+			}
+
+			go func(node *Node, interval time.Duration) {
+				node.checkSlaves(interval)
+				mu.Lock()
+				delete(checking, node)
+				mu.Unlock()
+			}(node, interval)
+		}
+	}
+}
+
+// 检查节点的所有slave，ping失败或者复制延迟超过MaxReplicationLag的slave会被移出轮换
+func (n *Node) checkSlaves(timeout time.Duration) {
+	slaves, _ := n.GetSlaves()
+	if len(slaves) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	maxLag := n.GetConfig().MaxReplicationLag
+	masterOffset := int64(-1)
+	if info, err := redis.String(n.CommandContext(ctx, "INFO", "replication")); err == nil {
+		if offset, exist := parseInfo(info)["master_repl_offset"]; exist {
+			masterOffset, _ = strconv.ParseInt(offset, 10, 64)
+		}
+	}
+
+	for _, slave := range slaves {
+		start := time.Now()
+		if _, err := slave.CommandContext(ctx, "PING"); err != nil {
+			slave.setHealth(false, 0, 0)
+			continue
+		}
+		latency := time.Since(start)
+
+		info, err := redis.String(slave.CommandContext(ctx, "INFO", "replication"))
+		if err != nil {
+			slave.setHealth(false, 0, 0)
+			continue
+		}
+		fields := parseInfo(info)
+		if status, exist := fields["master_link_status"]; exist && status != "up" {
+			slave.setHealth(false, 0, 0)
+			continue
+		}
+		lag := int64(0)
+		if offset, exist := fields["slave_repl_offset"]; exist && masterOffset >= 0 {
+			slaveOffset, _ := strconv.ParseInt(offset, 10, 64)
+			if lag = masterOffset - slaveOffset; lag < 0 {
+				lag = 0
+			}
+		}
+		if maxLag > 0 && lag > maxLag {
+			// 复制延迟过大时仍然记录延迟，便于通过ReplicationLag查看被移除的原因
+			atomic.StoreInt64(&slave.health.lag, lag)
+			slave.setHealth(false, latency, lag)
+			continue
+		}
+		slave.setHealth(true, latency, lag)
+	}
+}
+
+// 解析INFO命令返回的key:value格式
+func parseInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			fields[line[:i]] = line[i+1:]
+		}
+	}
+	return fields
+}
@@ -0,0 +1,87 @@
+package goredis
+
+import (
+	"github.com/vaughan0/go-ini"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSlaveSelector(t *testing.T) {
+	slaves := []*Node{
+		newAddrNode("127.0.0.1:6379", "", 0, defaultConfig()),
+		newAddrNode("127.0.0.1:6380", "", 0, defaultConfig()),
+		newAddrNode("127.0.0.1:6381", "", 0, defaultConfig()),
+	}
+
+	rr := &RoundRobinSelector{}
+	for i := 0; i < 6; i++ {
+		if node := rr.Select(slaves); node != slaves[i%3] {
+			t.Error("round robin select wrong at ", i)
+		}
+	}
+	// 计数溢出后继续轮询
+	rr = &RoundRobinSelector{next: math.MaxUint32}
+	if node := rr.Select(slaves); node != slaves[math.MaxUint32%3] {
+		t.Error("round robin select wrong at max uint32")
+	}
+	if node := rr.Select(slaves); node != slaves[0] {
+		t.Error("round robin select wrong after overflow")
+	}
+
+	slaves[0].setHealth(true, time.Millisecond*3, 0)
+	slaves[1].setHealth(true, time.Millisecond, 0)
+	slaves[2].setHealth(true, time.Millisecond*2, 0)
+	if node := (LatencySelector{}).Select(slaves); node != slaves[1] {
+		t.Error("latency selector should select the fastest slave")
+	}
+
+	slaves[0].definition.weight = 100
+	count := 0
+	for i := 0; i < 100; i++ {
+		if (WeightedSelector{}).Select(slaves) == slaves[0] {
+			count++
+		}
+	}
+	if count < 80 {
+		t.Error("weighted selector should prefer heavy slave,get ", count)
+	}
+
+	if _, err := newSlaveSelector("unknown"); err == nil {
+		t.Error("unknown selector should return error")
+	}
+}
+
+func TestGetSlaveFallback(t *testing.T) {
+	p := NewPool(Config{})
+	defer p.Close()
+	p.LoadNodes(ini.File{
+		"redis_node_default": ini.Section{
+			"scheme":         "redis://:@localhost:6379",
+			"slaves":         "slave1",
+			"slave_selector": "round_robin",
+		},
+		"redis_node_slave1": ini.Section{
+			"scheme": "redis://:@127.0.0.1:1",
+		},
+	})
+	node, _ := p.GetNode(DefaultNodeName)
+	slave1, _ := p.GetNode("slave1")
+	if _, ok := node.getSlaveSelector().(*RoundRobinSelector); !ok {
+		t.Error("slave selector not set from config")
+	}
+
+	if slave, err := node.GetSlave(); err != nil || slave != slave1 {
+		t.Error("want slave1 before health check,get ", slave, err)
+	}
+	node.checkSlaves(time.Second)
+	if slave1.Healthy() {
+		t.Error("unreachable slave should be unhealthy")
+	}
+	if slave, err := node.GetSlave(); err != nil || slave != node {
+		t.Error("want master when no slave is healthy,get ", slave, err)
+	}
+	if _, err := p.CommandOnSlave(DefaultNodeName, "PING"); err != nil {
+		t.Error("command on slave should fall back to master,err:", err.Error())
+	}
+}
@@ -1,31 +1,105 @@
 package goredis
 
 import (
+	"context"
 	"github.com/gomodule/redigo/redis"
+	"time"
 )
 
 type Helper struct {
+	pool     *Pool
 	nodeName string
+	ctx      context.Context
+	// 只读命令是否在slave上执行
+	readFromReplicas bool
+	// SetObject/GetObject等方法使用的编码方式
+	codec Codec
+	// 可缓存命令使用的本地缓存
+	cache *Cache
 }
 
 // 新建helper实例
 func NewHelper(nodeName ...string) *Helper {
+	return pool.NewHelper(nodeName...)
+}
+
+// 新建作用在p上的helper实例
+func (p *Pool) NewHelper(nodeName ...string) *Helper {
 	if len(nodeName) == 0 {
 		nodeName = make([]string, 1)
 		nodeName[0] = DefaultNodeName
 	}
-	return &Helper{nodeName: nodeName[0],}
+	return &Helper{pool: p, nodeName: nodeName[0],}
+}
+
+// 返回一个绑定了ctx的helper副本，副本执行的所有命令都受ctx的超时和取消控制
+func (h *Helper) WithContext(ctx context.Context) *Helper {
+	if ctx == nil {
+		panic("nil context")
+	}
+	h2 := *h
+	h2.ctx = ctx
+	return &h2
+}
+
+// 获取helper绑定的context，没有绑定时返回context.Background()
+func (h *Helper) Context() context.Context {
+	if h.ctx != nil {
+		return h.ctx
+	}
+	return context.Background()
+}
+
+// 返回一个读写分离的helper副本，副本的只读命令(见IsReadOnlyCommand)会通过Node.GetSlave选择slave执行，
+// 写命令仍然在master上执行；节点没有配置slave时所有命令都在master上执行
+func (h *Helper) ReadFromReplicas() *Helper {
+	h2 := *h
+	h2.readFromReplicas = true
+	return &h2
+}
+
+// 在helper绑定的节点上执行命令，使用本地缓存时可缓存的命令优先从缓存中读取
+func (h *Helper) command(command string, args ...interface{}) (interface{}, error) {
+	if h.cache != nil {
+		return h.cache.command(h.Context(), command, args, h.execute)
+	}
+	return h.execute(command, args...)
+}
+
+// 在helper绑定的节点上执行命令，不使用本地缓存
+func (h *Helper) execute(command string, args ...interface{}) (interface{}, error) {
+	if h.readFromReplicas && IsReadOnlyCommand(command) {
+		reply, err := h.pool.CommandOnSlaveContext(h.Context(), h.nodeName, command, args...)
+		if err != ErrNodeNotFound {
+			return resp2Reply(reply, err)
+		}
+	}
+	return resp2Reply(h.pool.CommandContext(h.Context(), h.nodeName, command, args...))
+}
+
+// 在helper绑定的节点上执行阻塞命令，读超时为block加上节点的ReadTimeout，block为0时一直等待
+// helper的ctx结束时中断阻塞并关闭连接，因此block为0的命令可以通过WithContext取消
+func (h *Helper) blockingCommand(block time.Duration, command string, args ...interface{}) (interface{}, error) {
+	node, err := h.pool.GetNode(h.nodeName)
+	if err != nil {
+		return nil, err
+	}
+	timeout := time.Duration(0)
+	if block > 0 {
+		timeout = block + node.GetConfig().readTimeout()
+	}
+	return resp2Reply(node.CommandContext(withReadTimeout(h.Context(), timeout), command, args...))
 }
 
 // get command
 func (h *Helper) Get(key string) (resp interface{}, err error) {
-	resp, err = Command(h.nodeName, "GET", key)
+	resp, err = h.command("GET", key)
 	return
 }
 
 // set command
 func (h *Helper) Set(key string, value interface{}) (err error) {
-	_, err = Command(h.nodeName, "SET", key, value)
+	_, err = h.command("SET", key, value)
 	return
 }
 
@@ -38,37 +112,39 @@ func (h *Helper) Del(keys ...string) (err error) {
 	for _, v := range keys {
 		keysInterface = append(keysInterface, v)
 	}
-	_, err = Command(h.nodeName, "DEL", keysInterface...)
+	_, err = h.command("DEL", keysInterface...)
 	return
 }
 
 // exists command
 func (h *Helper) Exists(key string) (exist bool, err error) {
-	exist, err = redis.Bool(Command(h.nodeName, "EXISTS", key))
+	exist, err = redis.Bool(h.command("EXISTS", key))
 	return
 }
 
 // expire command
 func (h *Helper) Expire(key string, seconds int) (err error) {
-	_, err = Command(h.nodeName, "EXPIRE", key, seconds)
+	_, err = h.command("EXPIRE", key, seconds)
 	return
 }
 
 // expireat command
 func (h *Helper) ExpireAt(key string, timestamp int64) (err error) {
-	_, err = Command(h.nodeName, "EXPIREAT", key, timestamp)
+	_, err = h.command("EXPIREAT", key, timestamp)
 	return
 }
 
-// keys command
+// keys command，KEYS会遍历整个数据库并阻塞redis，key很多时使用Scan代替
+//
+// Deprecated: 使用Scan
 func (h *Helper) Keys(pattern string) (keys []string, err error) {
-	keys, err = redis.Strings(Command(h.nodeName, "KEYS", pattern))
+	keys, err = redis.Strings(h.command("KEYS", pattern))
 	return
 }
 
 // persist command
 func (h *Helper) Persist(key string) (err error) {
-	if _, err := Command(h.nodeName, "PERSIST", key); err != nil {
+	if _, err := h.command("PERSIST", key); err != nil {
 		return err
 	}
 	return nil
@@ -76,19 +152,78 @@ func (h *Helper) Persist(key string) (err error) {
 
 // ttl command
 func (h *Helper) TTL(key string) (ttl int64, err error) {
-	ttl, err = redis.Int64(Command(h.nodeName, "TTL", key))
+	ttl, err = redis.Int64(h.command("TTL", key))
 	return
 }
 
 // setex command
 func (h *Helper) Setex(key string, second int, value interface{}) (err error) {
-	_, err = Command(h.nodeName, "SETEX", key, second, value)
+	_, err = h.command("SETEX", key, second, value)
+	return
+}
+
+// setnx command，key不存在并设置成功时ok为true
+func (h *Helper) Setnx(key string, value interface{}) (ok bool, err error) {
+	ok, err = redis.Bool(h.command("SETNX", key, value))
 	return
 }
 
-// setnx command
-func (h *Helper) Setnx(key string, value interface{}) (err error) {
-	_, err = Command(h.nodeName, "SETNX", key, value)
+// SET命令的选项
+type SetOptions struct {
+	// 过期时间，为整秒时使用EX，否则使用PX(向上取整到毫秒)，小于等于0时不设置过期时间
+	TTL time.Duration
+	// 只在key不存在时设置
+	NX bool
+	// 只在key存在时设置
+	XX bool
+	// 保留key原来的过期时间，需要redis 6.0以上
+	KeepTTL bool
+	// 返回key原来的值，需要redis 6.2以上，和NX一起使用需要redis 7.0以上
+	Get bool
+}
+
+// 带选项的set command，written表示是否写入成功(NX/XX条件不满足时为false)
+// 设置了Get时previous为key原来的值，key原来不存在时为nil
+func (h *Helper) SetWithOptions(key string, value interface{}, options SetOptions) (written bool, previous interface{}, err error) {
+	args := redis.Args{}.Add(key, value)
+	if options.TTL > 0 {
+		if options.TTL%time.Second == 0 {
+			args = args.Add("EX", int64(options.TTL/time.Second))
+		} else {
+			// 不足1毫秒的部分向上取整，避免发送PX 0
+			args = args.Add("PX", int64((options.TTL+time.Millisecond-1)/time.Millisecond))
+		}
+	}
+	if options.NX {
+		args = args.Add("NX")
+	}
+	if options.XX {
+		args = args.Add("XX")
+	}
+	if options.KeepTTL {
+		args = args.Add("KEEPTTL")
+	}
+	if options.Get {
+		args = args.Add("GET")
+	}
+
+	var reply interface{}
+	if reply, err = h.command("SET", args...); err != nil {
+		return
+	}
+	if !options.Get {
+		return reply != nil, nil, nil
+	}
+	// 设置了GET时返回的是原来的值，需要根据NX/XX判断是否写入
+	previous = reply
+	switch {
+	case options.NX:
+		written = previous == nil
+	case options.XX:
+		written = previous != nil
+	default:
+		written = true
+	}
 	return
 }
 
@@ -98,7 +233,7 @@ func (h *Helper) MSet(valueMap map[string]interface{}) (err error) {
 	for k, v := range valueMap {
 		values = append(values, k, v)
 	}
-	_, err = Command(h.nodeName, "MSET", values...)
+	_, err = h.command("MSET", values...)
 
 	return
 }
@@ -112,37 +247,37 @@ func (h *Helper) MGet(keys ...string) (values []interface{}, err error) {
 	for _, v := range keys {
 		keyInterface = append(keyInterface, v)
 	}
-	values, err = redis.Values(Command(h.nodeName, "MGET", keyInterface...))
+	values, err = redis.Values(h.command("MGET", keyInterface...))
 	return
 }
 
 // decr command
 func (h *Helper) Decr(key string) (newValue int64, err error) {
-	newValue, err = redis.Int64(Command(h.nodeName, "DECR", key))
+	newValue, err = redis.Int64(h.command("DECR", key))
 	return
 }
 
 // decrby command
 func (h *Helper) DecrBy(key string, decrNum int) (newValue int64, err error) {
-	newValue, err = redis.Int64(Command(h.nodeName, "DECRBY", key, decrNum))
+	newValue, err = redis.Int64(h.command("DECRBY", key, decrNum))
 	return
 }
 
 // incr command
 func (h *Helper) Incr(key string) (newValue int64, err error) {
-	newValue, err = redis.Int64(Command(h.nodeName, "INCR", key))
+	newValue, err = redis.Int64(h.command("INCR", key))
 	return
 }
 
 // incrby command
 func (h *Helper) IncrBy(key string, decrNum int) (newValue int64, err error) {
-	newValue, err = redis.Int64(Command(h.nodeName, "INCRBY", key, decrNum))
+	newValue, err = redis.Int64(h.command("INCRBY", key, decrNum))
 	return
 }
 
 // getset command
 func (h *Helper) GetSet(key string, value interface{}) (curValue interface{}, err error) {
-	curValue, err = Command(h.nodeName, "GETSET", key, value)
+	curValue, err = h.command("GETSET", key, value)
 	return
 }
 
@@ -156,19 +291,19 @@ func (h *Helper) HDel(key string, fields ...string) (err error) {
 	for _, v := range fields {
 		fieldsInterface = append(fieldsInterface, v)
 	}
-	_, err = Command(h.nodeName, "HDEL", fieldsInterface...)
+	_, err = h.command("HDEL", fieldsInterface...)
 	return
 }
 
 // hexist command
 func (h *Helper) HExists(key, field string) (exist bool, err error) {
-	exist, err = redis.Bool(Command(h.nodeName, "HEXISTS", key, field))
+	exist, err = redis.Bool(h.command("HEXISTS", key, field))
 	return
 }
 
 // hset command
 func (h *Helper) HSet(key, field string, value interface{}) (err error) {
-	_, err = Command(h.nodeName, "HSET", key, field, value)
+	_, err = h.command("HSET", key, field, value)
 	return
 }
 
@@ -176,37 +311,37 @@ func (h *Helper) HSet(key, field string, value interface{}) (err error) {
 func (h *Helper) HMset(key string, values interface{}) (err error) {
 	args := redis.Args{}.Add(key)
 	args = args.AddFlat(values)
-	_, err = Command(h.nodeName, "HMSET", args...)
+	_, err = h.command("HMSET", args...)
 	return
 }
 
 // hget command
 func (h *Helper) HGet(key, field string) (value interface{}, err error) {
-	value, err = Command(h.nodeName, "HGET", key, field)
+	value, err = h.command("HGET", key, field)
 	return
 }
 
 // hgetall command
 func (h *Helper) HGetAll(key string) (values []interface{}, err error) {
-	values, err = redis.Values(Command(h.nodeName, "HGETALL", key))
+	values, err = redis.Values(h.command("HGETALL", key))
 	return
 }
 
 // hkeys command
 func (h *Helper) HKeys(key string) (values []string, err error) {
-	values, err = redis.Strings(Command(h.nodeName, "HKEYS", key))
+	values, err = redis.Strings(h.command("HKEYS", key))
 	return
 }
 
 // hvals command
 func (h *Helper) HVals(key string) (values []interface{}, err error) {
-	values, err = redis.Values(Command(h.nodeName, "HVALS", key))
+	values, err = redis.Values(h.command("HVALS", key))
 	return
 }
 
 // hlen command
 func (h *Helper) HLen(key string) (length int64, err error) {
-	length, err = redis.Int64(Command(h.nodeName, "HLEN", key))
+	length, err = redis.Int64(h.command("HLEN", key))
 	return
 }
 
@@ -220,6 +355,6 @@ func (h *Helper) HMget(key string, fields ...string) (values interface{}, err er
 	for _, v := range fields {
 		fieldsInterface = append(fieldsInterface, v)
 	}
-	values, err = Command(h.nodeName, "HMGET", fieldsInterface...)
+	values, err = h.command("HMGET", fieldsInterface...)
 	return
 }
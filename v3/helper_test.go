@@ -1,6 +1,7 @@
 package goredis
 
 import (
+	"context"
 	"testing"
 	"github.com/vaughan0/go-ini"
 	"github.com/gomodule/redigo/redis"
@@ -8,8 +9,13 @@ import (
 )
 
 // init just 4 test
+// 为默认Pool加载default节点，已经存在时不重复加载
+// 不通过Init加载，避免先运行的测试标记isInit后TestInit中的Init不生效
 func testInit() {
-	Init(Config{}, ini.File{
+	if _, err := GetNode(); err == nil {
+		return
+	}
+	pool.LoadNodes(ini.File{
 		"redis_node_default": ini.Section{
 			"scheme": "redis://:@localhost:6379",
 		},
@@ -35,6 +41,24 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestWithContext(t *testing.T) {
+	testInit()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h := NewHelper().WithContext(ctx)
+	if err := h.Set("name", "scofield"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	cancel()
+	if _, err := h.Get("name"); err != context.Canceled {
+		t.Error("want context.Canceled,get ", err)
+	}
+	if _, err := NewHelper().Get("name"); err != nil {
+		t.Error(err.Error())
+	}
+}
+
 func TestDel(t *testing.T) {
 	testInit()
 	err := NewHelper().Del("name")
@@ -124,9 +148,57 @@ func TestSetex(t *testing.T) {
 
 func TestSetnx(t *testing.T) {
 	testInit()
-	err := NewHelper().Setnx("name", "scofield")
+	NewHelper().Set("name", "scofield")
+	ok, err := NewHelper().Setnx("name", "scofield")
 	if err != nil {
 		t.Error(err.Error())
+	} else if ok {
+		t.Error("want setnx fail on existing key")
+	}
+	NewHelper().Del("setnx_test")
+	defer NewHelper().Del("setnx_test")
+	if ok, err = NewHelper().Setnx("setnx_test", "scofield"); err != nil || !ok {
+		t.Error("want setnx success, get ", ok, err)
+	}
+}
+
+func TestSetWithOptions(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("set_options")
+	defer helper.Del("set_options")
+
+	written, _, err := helper.SetWithOptions("set_options", "v1", SetOptions{TTL: time.Millisecond * 1500, NX: true})
+	if err != nil || !written {
+		t.Error("want written, get ", written, err)
+		return
+	}
+	if ttl, _ := redis.Int64(helper.command("PTTL", "set_options")); ttl <= 1000 || ttl > 1500 {
+		t.Error("want pttl about 1500, get ", ttl)
+	}
+	if written, _, err = helper.SetWithOptions("set_options", "v2", SetOptions{NX: true}); err != nil || written {
+		t.Error("want not written, get ", written, err)
+	}
+
+	written, previous, err := helper.SetWithOptions("set_options", "v3", SetOptions{XX: true, KeepTTL: true, Get: true})
+	if old, _ := redis.String(previous, nil); err != nil || !written || old != "v1" {
+		t.Error("want written and previous v1, get ", written, old, err)
+	}
+	if ttl, _ := helper.TTL("set_options"); ttl <= 0 {
+		t.Error("want ttl kept, get ", ttl)
+	}
+
+	written, previous, err = helper.SetWithOptions("set_options", "v4", SetOptions{TTL: time.Minute, Get: true})
+	if old, _ := redis.String(previous, nil); err != nil || !written || old != "v3" {
+		t.Error("want written and previous v3, get ", written, old, err)
+	}
+	if ttl, _ := helper.TTL("set_options"); ttl <= 50 {
+		t.Error("want ttl about 60, get ", ttl)
+	}
+
+	// 不足1毫秒的过期时间向上取整为1毫秒
+	if written, _, err = helper.SetWithOptions("set_options", "v5", SetOptions{TTL: time.Microsecond * 500}); err != nil || !written {
+		t.Error("want written, get ", written, err)
 	}
 }
 
@@ -392,3 +464,42 @@ func TestHMget(t *testing.T) {
 		return
 	}
 }
+
+func TestReadFromReplicas(t *testing.T) {
+	p := NewPool(Config{})
+	defer p.Close()
+	// 使用不同的db模拟master和slave，以便区分命令在哪个节点上执行
+	p.LoadNodes(ini.File{
+		"redis_node_default": ini.Section{
+			"scheme": "redis://:@localhost:6379/0",
+			"slaves": "slave1",
+		},
+		"redis_node_slave1": ini.Section{
+			"scheme": "redis://:@localhost:6379/1",
+		},
+	})
+	if _, err := p.Command("slave1", "SET", "rw_name", "slave"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	h := p.NewHelper().ReadFromReplicas()
+	if err := h.Set("rw_name", "master"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	name, err := redis.String(h.Get("rw_name"))
+	if err != nil {
+		t.Error(err.Error())
+	} else if name != "slave" {
+		t.Error("read should go to slave,get ", name)
+	}
+	name, err = redis.String(p.NewHelper().Get("rw_name"))
+	if err != nil {
+		t.Error(err.Error())
+	} else if name != "master" {
+		t.Error("write should go to master,get ", name)
+	}
+	p.NewHelper().Del("rw_name")
+	p.Command("slave1", "DEL", "rw_name")
+}
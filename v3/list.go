@@ -0,0 +1,128 @@
+package goredis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"strconv"
+	"time"
+)
+
+const (
+	// LMOVE/BLMOVE中列表的左端
+	ListLeft = "LEFT"
+	// LMOVE/BLMOVE中列表的右端
+	ListRight = "RIGHT"
+)
+
+// lpush command，返回插入后列表的长度
+func (h *Helper) LPush(key string, values ...interface{}) (length int64, err error) {
+	length, err = redis.Int64(h.command("LPUSH", redis.Args{}.Add(key).Add(values...)...))
+	return
+}
+
+// rpush command，返回插入后列表的长度
+func (h *Helper) RPush(key string, values ...interface{}) (length int64, err error) {
+	length, err = redis.Int64(h.command("RPUSH", redis.Args{}.Add(key).Add(values...)...))
+	return
+}
+
+// lpop command，列表为空时value为nil
+func (h *Helper) LPop(key string) (value interface{}, err error) {
+	value, err = h.command("LPOP", key)
+	return
+}
+
+// rpop command，列表为空时value为nil
+func (h *Helper) RPop(key string) (value interface{}, err error) {
+	value, err = h.command("RPOP", key)
+	return
+}
+
+// lrange command
+func (h *Helper) LRange(key string, start, stop int64) (values []interface{}, err error) {
+	values, err = redis.Values(h.command("LRANGE", key, start, stop))
+	return
+}
+
+// llen command
+func (h *Helper) LLen(key string) (length int64, err error) {
+	length, err = redis.Int64(h.command("LLEN", key))
+	return
+}
+
+// lindex command，index超出范围时value为nil
+func (h *Helper) LIndex(key string, index int64) (value interface{}, err error) {
+	value, err = h.command("LINDEX", key, index)
+	return
+}
+
+// lset command
+func (h *Helper) LSet(key string, index int64, value interface{}) (err error) {
+	_, err = h.command("LSET", key, index, value)
+	return
+}
+
+// lrem command，返回移除的元素数量
+func (h *Helper) LRem(key string, count int64, value interface{}) (removed int64, err error) {
+	removed, err = redis.Int64(h.command("LREM", key, count, value))
+	return
+}
+
+// ltrim command
+func (h *Helper) LTrim(key string, start, stop int64) (err error) {
+	_, err = h.command("LTRIM", key, start, stop)
+	return
+}
+
+// linsert command，before为true时插入到pivot之前，否则插入到pivot之后
+// 返回插入后列表的长度，pivot不存在时返回-1
+func (h *Helper) LInsert(key string, before bool, pivot, value interface{}) (length int64, err error) {
+	position := "AFTER"
+	if before {
+		position = "BEFORE"
+	}
+	length, err = redis.Int64(h.command("LINSERT", key, position, pivot, value))
+	return
+}
+
+// lmove command，srcPos和destPos为ListLeft或ListRight，source为空时value为nil，需要redis 6.2以上
+func (h *Helper) LMove(source, destination, srcPos, destPos string) (value interface{}, err error) {
+	value, err = h.command("LMOVE", source, destination, srcPos, destPos)
+	return
+}
+
+// blpop command，依次检查keys并从第一个非空列表的左端弹出元素，都为空时最多阻塞timeout(0表示一直阻塞)
+// 超时时key为空，value为nil；阻塞期间不受连接读超时的限制，可以通过WithContext的ctx取消阻塞
+func (h *Helper) BLPop(timeout time.Duration, keys ...string) (key string, value interface{}, err error) {
+	return h.blockingPop("BLPOP", timeout, keys)
+}
+
+// brpop command，见BLPop，元素从列表的右端弹出
+func (h *Helper) BRPop(timeout time.Duration, keys ...string) (key string, value interface{}, err error) {
+	return h.blockingPop("BRPOP", timeout, keys)
+}
+
+// blmove command，LMove的阻塞版本，source为空时最多阻塞timeout(0表示一直阻塞)，超时时value为nil
+// 阻塞期间不受连接读超时的限制，需要redis 6.2以上
+func (h *Helper) BLMove(source, destination, srcPos, destPos string, timeout time.Duration) (value interface{}, err error) {
+	value, err = h.blockingCommand(timeout, "BLMOVE", source, destination, srcPos, destPos, blockSeconds(timeout))
+	return
+}
+
+// 执行BLPOP/BRPOP
+func (h *Helper) blockingPop(command string, timeout time.Duration, keys []string) (key string, value interface{}, err error) {
+	var values []interface{}
+	values, err = redis.Values(h.blockingCommand(timeout, command, redis.Args{}.AddFlat(keys).Add(blockSeconds(timeout))...))
+	if err == redis.ErrNil {
+		return "", nil, nil
+	}
+	if err != nil {
+		return
+	}
+	_, err = redis.Scan(values, &key, &value)
+	return
+}
+
+// 阻塞命令的超时参数，单位秒，redis 6.0以上支持小数
+func blockSeconds(timeout time.Duration) string {
+	return strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64)
+}
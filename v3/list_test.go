@@ -0,0 +1,123 @@
+package goredis
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("list_test", "list_dest")
+	defer helper.Del("list_test", "list_dest")
+
+	if length, err := helper.RPush("list_test", "b", "c"); err != nil || length != 2 {
+		t.Error("want length 2, get ", length, err)
+		return
+	}
+	helper.LPush("list_test", "a")
+	if length, err := helper.LInsert("list_test", false, "c", "d"); err != nil || length != 4 {
+		t.Error("want length 4, get ", length, err)
+	}
+	values, err := redis.Strings(helper.LRange("list_test", 0, -1))
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(values) != 4 || values[0] != "a" || values[3] != "d" {
+		t.Error("want [a b c d], get ", values)
+	}
+
+	if err = helper.LSet("list_test", 1, "x"); err != nil {
+		t.Error(err.Error())
+	}
+	if value, err := redis.String(helper.LIndex("list_test", 1)); err != nil || value != "x" {
+		t.Error("want x, get ", value, err)
+	}
+	if removed, err := helper.LRem("list_test", 0, "x"); err != nil || removed != 1 {
+		t.Error("want 1 removed, get ", removed, err)
+	}
+	if err = helper.LTrim("list_test", 0, 1); err != nil {
+		t.Error(err.Error())
+	}
+	if length, err := helper.LLen("list_test"); err != nil || length != 2 {
+		t.Error("want length 2, get ", length, err)
+	}
+	if value, err := redis.String(helper.LMove("list_test", "list_dest", ListLeft, ListRight)); err != nil || value != "a" {
+		t.Error("want a, get ", value, err)
+	}
+	if value, err := redis.String(helper.RPop("list_test")); err != nil || value != "c" {
+		t.Error("want c, get ", value, err)
+	}
+	if value, err := helper.LPop("list_test"); err != nil || value != nil {
+		t.Error("want nil, get ", value, err)
+	}
+}
+
+func TestBlockingPop(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("list_block", "list_block_dest")
+	defer helper.Del("list_block", "list_block_dest")
+
+	// 阻塞的时间超过连接的读超时也不会出错
+	p := NewPool(Config{ReadTimeout: time.Millisecond * 50})
+	defer p.Close()
+	p.SetNode(DefaultNodeName, "redis://:@localhost:6379")
+	blocking := p.NewHelper()
+
+	go func() {
+		time.Sleep(time.Millisecond * 300)
+		helper.RPush("list_block", "job")
+	}()
+	key, value, err := blocking.BLPop(time.Second*2, "list_block_empty", "list_block")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if job, _ := redis.String(value, nil); key != "list_block" || job != "job" {
+		t.Error("want list_block job, get ", key, job)
+	}
+
+	start := time.Now()
+	if key, value, err = blocking.BRPop(time.Millisecond*200, "list_block"); err != nil || key != "" || value != nil {
+		t.Error("want timeout, get ", key, value, err)
+	} else if time.Since(start) < time.Millisecond*150 {
+		t.Error("want BRPOP block")
+	}
+
+	helper.RPush("list_block", "next")
+	if job, err := redis.String(blocking.BLMove("list_block", "list_block_dest", ListRight, ListLeft, time.Second)); err != nil || job != "next" {
+		t.Error("want next, get ", job, err)
+	}
+}
+
+func TestBlockingPopCancel(t *testing.T) {
+	p := NewPool(Config{MaxActive: 1, Wait: true})
+	defer p.Close()
+	p.SetNode(DefaultNodeName, "redis://:@localhost:6379")
+	helper := p.NewHelper()
+	helper.Del("list_block_cancel")
+
+	// timeout为0时一直阻塞，ctx取消后立即返回并释放连接
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		cancel()
+	}()
+	if _, _, err := helper.WithContext(ctx).BLPop(0, "list_block_cancel"); err != context.Canceled {
+		t.Error("want context.Canceled, get ", err)
+	}
+	node, _ := p.GetNode(DefaultNodeName)
+	if active := node.GetPool().ActiveCount(); active != 0 {
+		t.Error("want no active connection, get ", active)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := helper.WithContext(ctx).RPush("list_block_cancel", "job"); err != nil {
+		t.Error("want connection released, get ", err)
+	}
+	helper.Del("list_block_cancel")
+}
@@ -0,0 +1,377 @@
+package goredis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/gomodule/redigo/redis"
+	"sync"
+	"time"
+)
+
+var (
+	// 重试结束后仍然没有获得锁
+	ErrNotObtained = errors.New("redis: lock not obtained")
+	// 锁已经过期或者被其他持有者获得
+	ErrLockNotHeld = errors.New("redis: lock not held")
+)
+
+var (
+	// 只有token匹配时才删除锁
+	lockReleaseScript = NewScript(1, `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`)
+	// 只有token匹配时才延长锁的过期时间
+	lockRefreshScript = NewScript(1, `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
+)
+
+const (
+	// redlock模式下单个节点请求的最短超时时间
+	lockMinNodeTimeout = time.Millisecond * 50
+	// redlock模式下估计的时钟漂移比例
+	lockClockDriftFactor = 0.01
+)
+
+// 获取锁失败后的重试策略
+type RetryStrategy interface {
+	// 返回下一次重试前的等待时间，小于等于0时不再重试
+	NextBackoff() time.Duration
+}
+
+type noRetry struct{}
+
+func (noRetry) NextBackoff() time.Duration {
+	return 0
+}
+
+// 不重试
+func NoRetry() RetryStrategy {
+	return noRetry{}
+}
+
+type linearBackoff time.Duration
+
+func (b linearBackoff) NextBackoff() time.Duration {
+	return time.Duration(b)
+}
+
+// 每次等待固定的时间后重试，一般和LimitRetry或者带deadline的ctx一起使用
+func LinearBackoff(backoff time.Duration) RetryStrategy {
+	return linearBackoff(backoff)
+}
+
+type exponentialBackoff struct {
+	min, max time.Duration
+	next     time.Duration
+}
+
+func (b *exponentialBackoff) NextBackoff() time.Duration {
+	if b.next == 0 {
+		b.next = b.min
+	}
+	backoff := b.next
+	if b.next *= 2; b.next > b.max {
+		b.next = b.max
+	}
+	return backoff
+}
+
+// 等待时间从min开始每次翻倍，最长为max
+func ExponentialBackoff(min, max time.Duration) RetryStrategy {
+	return &exponentialBackoff{min: min, max: max}
+}
+
+type limitRetry struct {
+	strategy RetryStrategy
+	max      int
+	count    int
+}
+
+func (l *limitRetry) NextBackoff() time.Duration {
+	if l.count >= l.max {
+		return 0
+	}
+	l.count++
+	return l.strategy.NextBackoff()
+}
+
+// 最多重试max次
+func LimitRetry(strategy RetryStrategy, max int) RetryStrategy {
+	return &limitRetry{strategy: strategy, max: max}
+}
+
+// 获取锁的选项
+type LockOptions struct {
+	// 获取锁失败后的重试策略，为nil时不重试
+	// 重试策略是有状态的，每次Obtain都需要新建
+	Retry RetryStrategy
+	// 为true时在后台每隔ttl/3自动延长锁的过期时间，直到Release或者锁丢失
+	AutoRefresh bool
+}
+
+// 分布式锁，在一个节点上使用SET NX PX加锁，配置多个节点时使用Redlock算法，需要在超过半数的节点上加锁成功
+type Locker struct {
+	pool      *Pool
+	nodeNames []string
+}
+
+// 新建使用默认Pool的Locker，见Pool.NewLocker
+func NewLocker(nodeNames ...string) *Locker {
+	return pool.NewLocker(nodeNames...)
+}
+
+// 新建Locker，没有指定节点时使用默认节点，指定多个节点时使用Redlock模式，这些节点应当是相互独立的master
+func (p *Pool) NewLocker(nodeNames ...string) *Locker {
+	if len(nodeNames) == 0 {
+		nodeNames = []string{DefaultNodeName}
+	}
+	return &Locker{pool: p, nodeNames: nodeNames}
+}
+
+// 新建在helper绑定节点上加锁的Locker
+func (h *Helper) Locker() *Locker {
+	return h.pool.NewLocker(h.nodeName)
+}
+
+// 获取所有加锁的节点
+func (l *Locker) nodes() (nodes []*Node, err error) {
+	nodes = make([]*Node, 0, len(l.nodeNames))
+	for _, nodeName := range l.nodeNames {
+		var node *Node
+		if node, err = l.pool.GetNode(nodeName); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return
+}
+
+// 获取key上的锁，锁在ttl之后自动过期，options可以为nil
+// 没有获得锁并且重试结束时返回ErrNotObtained，ctx结束时返回ctx.Err()
+func (l *Locker) Obtain(ctx context.Context, key string, ttl time.Duration, options *LockOptions) (lock *Lock, err error) {
+	var nodes []*Node
+	if nodes, err = l.nodes(); err != nil {
+		return
+	}
+	var token string
+	if token, err = newLockToken(); err != nil {
+		return
+	}
+
+	retry := NoRetry()
+	if options != nil && options.Retry != nil {
+		retry = options.Retry
+	}
+	lock = &Lock{nodes: nodes, key: key, token: token, ttl: ttl}
+	for {
+		var ok bool
+		if ok, err = lock.obtain(ctx); ok {
+			break
+		}
+		if err != nil && len(nodes) == 1 {
+			return nil, err
+		}
+
+		backoff := retry.NextBackoff()
+		if backoff <= 0 {
+			return nil, ErrNotObtained
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if options != nil && options.AutoRefresh {
+		lock.startWatchdog()
+	}
+	return lock, nil
+}
+
+// 生成随机的token，用于标识锁的持有者
+func newLockToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// 已经获得的锁
+type Lock struct {
+	nodes []*Node
+	key   string
+	token string
+	ttl   time.Duration
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	lost     chan struct{}
+}
+
+// 获取锁的key
+func (lock *Lock) Key() string {
+	return lock.key
+}
+
+// 获取锁的token
+func (lock *Lock) Token() string {
+	return lock.token
+}
+
+// 获取锁剩余的过期时间，锁已经不再被持有时返回ErrLockNotHeld，Redlock模式下使用第一个节点的结果
+func (lock *Lock) TTL(ctx context.Context) (ttl time.Duration, err error) {
+	for _, node := range lock.nodes {
+		var token string
+		if token, err = redis.String(node.CommandContext(ctx, "GET", lock.key)); err != nil && err != redis.ErrNil {
+			continue
+		}
+		if token != lock.token {
+			return 0, ErrLockNotHeld
+		}
+		var ms int64
+		if ms, err = redis.Int64(node.CommandContext(ctx, "PTTL", lock.key)); err != nil {
+			continue
+		}
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	return
+}
+
+// 延长锁的过期时间为ttl，锁已经不再被持有时返回ErrLockNotHeld
+func (lock *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	return lock.result(lock.eval(ctx, lockRefreshScript, int64(ttl/time.Millisecond)))
+}
+
+// 释放锁并停止自动续期，锁已经不再被持有时返回ErrLockNotHeld
+func (lock *Lock) Release(ctx context.Context) error {
+	if lock.stop != nil {
+		lock.stopOnce.Do(func() {
+			close(lock.stop)
+		})
+	}
+	return lock.result(lock.eval(ctx, lockReleaseScript))
+}
+
+// 获取在自动续期失败(锁已经丢失)时关闭的channel，没有开启自动续期时返回nil
+func (lock *Lock) Lost() <-chan struct{} {
+	return lock.lost
+}
+
+// 判断成功的节点数是否达到要求，单个节点时需要成功，多个节点时需要超过半数
+func (lock *Lock) quorum(success int) bool {
+	return success >= len(lock.nodes)/2+1
+}
+
+// 将脚本的执行结果转换为error，没有达到要求并且没有出错时返回ErrLockNotHeld
+func (lock *Lock) result(success int, err error) error {
+	if lock.quorum(success) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ErrLockNotHeld
+}
+
+// 在所有节点上加锁，Redlock模式下加锁的总耗时需要小于ttl，失败时释放已经获得的锁
+func (lock *Lock) obtain(ctx context.Context) (ok bool, err error) {
+	if len(lock.nodes) == 1 {
+		var reply interface{}
+		reply, err = lock.nodes[0].CommandContext(ctx, "SET", lock.key, lock.token, "PX", int64(lock.ttl/time.Millisecond), "NX")
+		return err == nil && reply != nil, err
+	}
+
+	start := time.Now()
+	success, _ := lock.each(ctx, func(ctx context.Context, node *Node) (bool, error) {
+		reply, err := node.CommandContext(ctx, "SET", lock.key, lock.token, "PX", int64(lock.ttl/time.Millisecond), "NX")
+		return err == nil && reply != nil, err
+	})
+	drift := time.Duration(float64(lock.ttl)*lockClockDriftFactor) + 2*time.Millisecond
+	if lock.quorum(success) && time.Since(start)+drift < lock.ttl {
+		return true, nil
+	}
+	lock.eval(context.Background(), lockReleaseScript)
+	return false, nil
+}
+
+// 在所有节点上执行脚本，返回脚本返回1的节点数以及最后一个出错节点的错误
+func (lock *Lock) eval(ctx context.Context, script *Script, args ...interface{}) (int, error) {
+	return lock.each(ctx, func(ctx context.Context, node *Node) (bool, error) {
+		reply, err := redis.Int64(node.RunScript(ctx, script, redis.Args{}.Add(lock.key, lock.token).Add(args...)...))
+		return err == nil && reply == 1, err
+	})
+}
+
+// 并发地在所有节点上执行fn，返回成功的节点数以及最后一个出错节点的错误
+// Redlock模式下每个节点的超时时间为ttl的1/10(最少lockMinNodeTimeout)，避免单个节点不可用时阻塞过久
+func (lock *Lock) each(ctx context.Context, fn func(ctx context.Context, node *Node) (bool, error)) (success int, err error) {
+	if len(lock.nodes) == 1 {
+		var ok bool
+		if ok, err = fn(ctx, lock.nodes[0]); ok {
+			success = 1
+		}
+		return
+	}
+
+	timeout := lock.ttl / 10
+	if timeout < lockMinNodeTimeout {
+		timeout = lockMinNodeTimeout
+	}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	for _, node := range lock.nodes {
+		wg.Add(1)
+		go func(node *Node) {
+			defer wg.Done()
+			nodeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			ok, nodeErr := fn(nodeCtx, node)
+			mu.Lock()
+			defer mu.Unlock()
+			if ok {
+				success++
+			} else if nodeErr != nil {
+				err = nodeErr
+			}
+		}(node)
+	}
+	wg.Wait()
+	return
+}
+
+// 在后台每隔ttl/3延长锁的过期时间，续期失败(锁已经丢失)时关闭Lost
+func (lock *Lock) startWatchdog() {
+	lock.stop = make(chan struct{})
+	lock.lost = make(chan struct{})
+	ttl := lock.ttl
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-lock.stop:
+				return
+			case <-ticker.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), ttl/3)
+			err := lock.Refresh(ctx, ttl)
+			cancel()
+			if err == ErrLockNotHeld {
+				select {
+				case <-lock.stop:
+					// 已经被释放
+				default:
+					close(lock.lost)
+				}
+				return
+			}
+			// 其他错误可能是网络抖动，等待下一次续期
+		}
+	}()
+}
@@ -0,0 +1,146 @@
+package goredis
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	testInit()
+	NewHelper().Del("lock_test")
+	locker := NewLocker()
+	ctx := context.Background()
+
+	lock, err := locker.Obtain(ctx, "lock_test", time.Second, nil)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if _, err = locker.Obtain(ctx, "lock_test", time.Second, nil); err != ErrNotObtained {
+		t.Error("want ErrNotObtained, get ", err)
+	}
+
+	if err = lock.Refresh(ctx, time.Second*10); err != nil {
+		t.Error(err.Error())
+	}
+	if ttl, err := lock.TTL(ctx); err != nil || ttl <= time.Second*5 {
+		t.Error("want ttl about 10s, get ", ttl, err)
+	}
+
+	// 其他持有者的token不能释放锁
+	fake := &Lock{nodes: lock.nodes, key: lock.key, token: "fake", ttl: lock.ttl}
+	if err = fake.Release(ctx); err != ErrLockNotHeld {
+		t.Error("want ErrLockNotHeld, get ", err)
+	}
+	if err = lock.Release(ctx); err != nil {
+		t.Error(err.Error())
+	}
+	if err = lock.Release(ctx); err != ErrLockNotHeld {
+		t.Error("want ErrLockNotHeld, get ", err)
+	}
+}
+
+func TestLockRetry(t *testing.T) {
+	testInit()
+	NewHelper().Del("lock_retry")
+	locker := NewHelper().Locker()
+	ctx := context.Background()
+
+	lock, err := locker.Obtain(ctx, "lock_retry", time.Second, nil)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		lock.Release(ctx)
+	}()
+
+	// 锁被释放后重试成功
+	start := time.Now()
+	other, err := locker.Obtain(ctx, "lock_retry", time.Second, &LockOptions{
+		Retry: LimitRetry(ExponentialBackoff(time.Millisecond*20, time.Millisecond*100), 20),
+	})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer other.Release(ctx)
+	if time.Since(start) < time.Millisecond*100 {
+		t.Error("want obtain after lock released")
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*100)
+	defer cancel()
+	if _, err = locker.Obtain(timeoutCtx, "lock_retry", time.Second, &LockOptions{Retry: LinearBackoff(time.Millisecond * 30)}); err != context.DeadlineExceeded {
+		t.Error("want context deadline exceeded, get ", err)
+	}
+}
+
+func TestLockAutoRefresh(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("lock_refresh")
+	ctx := context.Background()
+
+	lock, err := helper.Locker().Obtain(ctx, "lock_refresh", time.Millisecond*300, &LockOptions{AutoRefresh: true})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	time.Sleep(time.Millisecond * 600)
+	if ttl, err := lock.TTL(ctx); err != nil || ttl <= 0 {
+		t.Error("want lock refreshed, get ", ttl, err)
+	}
+
+	// 锁被删除后watchdog发现锁丢失
+	helper.Del("lock_refresh")
+	select {
+	case <-lock.Lost():
+	case <-time.After(time.Second):
+		t.Error("want lock lost")
+	}
+	if err = lock.Release(ctx); err != ErrLockNotHeld {
+		t.Error("want ErrLockNotHeld, get ", err)
+	}
+}
+
+func TestRedlock(t *testing.T) {
+	p := NewPool(Config{})
+	defer p.Close()
+	p.SetNode("lock_1", "redis://:@localhost:6379/1")
+	p.SetNode("lock_2", "redis://:@localhost:6379/2")
+	p.SetNode("lock_3", "redis://:@localhost:6379/3")
+	ctx := context.Background()
+	for _, nodeName := range []string{"lock_1", "lock_2", "lock_3"} {
+		p.NewHelper(nodeName).Del("redlock")
+	}
+
+	// 一个节点上的锁已经被其他持有者获得，仍然可以在多数节点上加锁
+	p.NewHelper("lock_3").Set("redlock", "other")
+	locker := p.NewLocker("lock_1", "lock_2", "lock_3")
+	lock, err := locker.Obtain(ctx, "redlock", time.Second, nil)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if err = lock.Refresh(ctx, time.Second*2); err != nil {
+		t.Error(err.Error())
+	}
+	if err = lock.Release(ctx); err != nil {
+		t.Error(err.Error())
+	}
+
+	// 多数节点上的锁被其他持有者获得时加锁失败，并且不会残留已经获得的锁
+	p.NewHelper("lock_2").Set("redlock", "other")
+	if _, err = locker.Obtain(ctx, "redlock", time.Second, nil); err != ErrNotObtained {
+		t.Error("want ErrNotObtained, get ", err)
+	}
+	if exist, _ := p.NewHelper("lock_1").Exists("redlock"); exist {
+		t.Error("want lock on lock_1 released")
+	}
+	for _, nodeName := range []string{"lock_1", "lock_2", "lock_3"} {
+		p.NewHelper(nodeName).Del("redlock")
+	}
+}
@@ -0,0 +1,435 @@
+package goredis
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"time"
+)
+
+const (
+	// pipeline默认每批发送的命令数量
+	DefaultPipelineChunkSize = 1000
+)
+
+// pipeline，将多条命令在一次网络往返中发送，命令的结果在Exec之后通过PipelineCmd获取
+// pipeline不是并发安全的
+type Pipeline struct {
+	cmdQueue
+	node      *Node
+	err       error
+	ctx       context.Context
+	chunkSize int
+}
+
+// 待执行的命令队列，提供与Helper同名的方法将命令加入队列
+type cmdQueue struct {
+	cmds []*PipelineCmd
+}
+
+// pipeline中的一条命令
+type PipelineCmd struct {
+	command string
+	args    []interface{}
+	reply   interface{}
+	err     error
+	// 通过RunScript加入队列的脚本，返回NOSCRIPT时使用EVAL重试
+	script *Script
+}
+
+// 新建节点上的pipeline
+func (n *Node) Pipeline() *Pipeline {
+	return &Pipeline{node: n, chunkSize: DefaultPipelineChunkSize}
+}
+
+// 新建helper绑定节点上的pipeline，pipeline执行时使用helper绑定的context
+func (h *Helper) Pipeline() *Pipeline {
+	node, err := h.pool.GetNode(h.nodeName)
+	return &Pipeline{node: node, err: err, ctx: h.ctx, chunkSize: DefaultPipelineChunkSize}
+}
+
+// 设置每批发送的命令数量，命令很多时会分批发送，避免单次写入和读取的数据过大
+func (p *Pipeline) SetChunkSize(size int) *Pipeline {
+	if size > 0 {
+		p.chunkSize = size
+	}
+	return p
+}
+
+// 获取已经加入队列的命令数量
+func (q *cmdQueue) Len() int {
+	return len(q.cmds)
+}
+
+// 将命令加入队列
+func (q *cmdQueue) Do(command string, args ...interface{}) *PipelineCmd {
+	cmd := &PipelineCmd{command: command, args: args}
+	q.cmds = append(q.cmds, cmd)
+	return cmd
+}
+
+// 执行pipeline中的所有命令，见ExecContext
+func (p *Pipeline) Exec() ([]*PipelineCmd, error) {
+	ctx := p.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return p.ExecContext(ctx)
+}
+
+// 执行pipeline中的所有命令，执行后pipeline会被清空，可以继续使用
+// 返回所有命令，以及第一个出错命令的错误，每条命令的结果和错误通过PipelineCmd获取
+// 集群节点会按照key所在的分片分组发送，收到MOVED/ASK的命令会单独重试
+func (p *Pipeline) ExecContext(ctx context.Context) (cmds []*PipelineCmd, err error) {
+	cmds, p.cmds = p.cmds, nil
+	if len(cmds) == 0 {
+		return
+	}
+	if p.err != nil {
+		setCmdsErr(cmds, p.err)
+		return cmds, p.err
+	}
+
+	if cluster := p.node.getCluster(); cluster != nil {
+		p.execCluster(ctx, cluster, cmds)
+	} else {
+		var conn redis.Conn
+		if conn, err = p.node.GetConnContext(ctx); err != nil {
+			setCmdsErr(cmds, err)
+			return
+		}
+		p.execConn(ctx, conn, cmds)
+	}
+
+	for _, cmd := range cmds {
+		if cmd.script != nil && isNoScript(cmd.err) {
+			cmd.reply, cmd.err = p.node.CommandContext(ctx, "EVAL", cmd.evalArgs()...)
+		}
+	}
+	for _, cmd := range cmds {
+		cmd.reply = toRESP2(cmd.reply)
+	}
+	for _, cmd := range cmds {
+		if cmd.err != nil {
+			return cmds, cmd.err
+		}
+	}
+	return
+}
+
+// 在集群上执行命令，按照分片分组后分别发送
+func (p *Pipeline) execCluster(ctx context.Context, cluster *clusterClient, cmds []*PipelineCmd) {
+	groups := make(map[string][]*PipelineCmd)
+	for _, cmd := range cmds {
+		var (
+			addr string
+			err  error
+		)
+		if key, ok := commandKey(cmd.command, cmd.args); ok {
+			addr, err = cluster.slotAddr(Slot(key))
+		} else {
+			addr, err = cluster.randomAddr()
+		}
+		if err != nil {
+			cmd.err = err
+			continue
+		}
+		groups[addr] = append(groups[addr], cmd)
+	}
+
+	for addr, group := range groups {
+		conn, err := getConnContext(ctx, cluster.getPool(addr))
+		if err != nil {
+			setCmdsErr(group, err)
+			continue
+		}
+		p.execConn(ctx, conn, group)
+	}
+
+	for _, cmd := range cmds {
+		if redirect, ok := cmd.err.(redis.Error); ok {
+			if kind, _, _ := parseRedirect(redirect); kind != "" {
+				cmd.reply, cmd.err = cluster.do(ctx, cmd.command, cmd.args...)
+			}
+		}
+	}
+}
+
+// 通过conn分批发送命令并读取结果，执行完毕后释放conn
+// ctx被取消时立即返回，所有命令的错误为ctx.Err()
+func (p *Pipeline) execConn(ctx context.Context, conn redis.Conn, cmds []*PipelineCmd) {
+	// 结果先写入results，执行结束后再复制到cmds，避免ctx取消后与后台的请求并发写入
+	results := make([]PipelineCmd, len(cmds))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer conn.Close()
+
+		for start := 0; start < len(results); start += p.chunkSize {
+			end := start + p.chunkSize
+			if end > len(results) {
+				end = len(results)
+			}
+			if received, err := sendChunk(ctx, conn, cmds[start:end], results[start:end]); err != nil {
+				// 已经收到结果的命令保留结果，包括nil结果
+				for i := start + received; i < len(results); i++ {
+					results[i].err = err
+				}
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+		for i, cmd := range cmds {
+			cmd.reply, cmd.err = results[i].reply, results[i].err
+		}
+	case <-ctx.Done():
+		setCmdsErr(cmds, ctx.Err())
+	}
+}
+
+// 发送一批命令并将结果写入results，返回收到结果的命令数量，连接出错时返回error
+func sendChunk(ctx context.Context, conn redis.Conn, cmds []*PipelineCmd, results []PipelineCmd) (received int, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	for _, cmd := range cmds {
+		if err = conn.Send(cmd.command, cmd.args...); err != nil {
+			return
+		}
+	}
+	if err = conn.Flush(); err != nil {
+		return
+	}
+
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			err = context.DeadlineExceeded
+			return
+		}
+	}
+	for i := range results {
+		var reply interface{}
+		if timeout > 0 {
+			reply, err = redis.ReceiveWithTimeout(conn, timeout)
+		} else {
+			reply, err = conn.Receive()
+		}
+		if _, ok := err.(redis.Error); !ok && err != nil {
+			// 连接出错，剩下的命令都无法得到结果
+			return
+		}
+		results[i].reply, results[i].err = reply, err
+		received++
+	}
+	return received, nil
+}
+
+// 设置所有命令的错误
+func setCmdsErr(cmds []*PipelineCmd, err error) {
+	for _, cmd := range cmds {
+		cmd.err = err
+	}
+}
+
+// 获取命令名称
+func (c *PipelineCmd) Command() string {
+	return c.command
+}
+
+// 获取命令参数
+func (c *PipelineCmd) Args() []interface{} {
+	return c.args
+}
+
+// 获取命令的原始结果和错误
+func (c *PipelineCmd) Reply() (interface{}, error) {
+	return c.reply, c.err
+}
+
+// 获取命令的错误
+func (c *PipelineCmd) Err() error {
+	return c.err
+}
+
+// 将结果转换为string
+func (c *PipelineCmd) String() (string, error) {
+	return redis.String(c.reply, c.err)
+}
+
+// 将结果转换为int64
+func (c *PipelineCmd) Int64() (int64, error) {
+	return redis.Int64(c.reply, c.err)
+}
+
+// 将结果转换为float64
+func (c *PipelineCmd) Float64() (float64, error) {
+	return redis.Float64(c.reply, c.err)
+}
+
+// 将结果转换为[]byte
+func (c *PipelineCmd) Bytes() ([]byte, error) {
+	return redis.Bytes(c.reply, c.err)
+}
+
+// 将结果转换为bool
+func (c *PipelineCmd) Bool() (bool, error) {
+	return redis.Bool(c.reply, c.err)
+}
+
+// 将结果转换为[]string
+func (c *PipelineCmd) Strings() ([]string, error) {
+	return redis.Strings(c.reply, c.err)
+}
+
+// 将HGETALL等返回的成对结果转换为map[string]string
+func (c *PipelineCmd) StringMap() (map[string]string, error) {
+	return redis.StringMap(c.reply, c.err)
+}
+
+// 将结果转换为[]interface{}
+func (c *PipelineCmd) Values() ([]interface{}, error) {
+	return redis.Values(c.reply, c.err)
+}
+
+// get command
+func (q *cmdQueue) Get(key string) *PipelineCmd {
+	return q.Do("GET", key)
+}
+
+// set command
+func (q *cmdQueue) Set(key string, value interface{}) *PipelineCmd {
+	return q.Do("SET", key, value)
+}
+
+// del command
+func (q *cmdQueue) Del(keys ...string) *PipelineCmd {
+	return q.Do("DEL", redis.Args{}.AddFlat(keys)...)
+}
+
+// exists command
+func (q *cmdQueue) Exists(key string) *PipelineCmd {
+	return q.Do("EXISTS", key)
+}
+
+// expire command
+func (q *cmdQueue) Expire(key string, seconds int) *PipelineCmd {
+	return q.Do("EXPIRE", key, seconds)
+}
+
+// expireat command
+func (q *cmdQueue) ExpireAt(key string, timestamp int64) *PipelineCmd {
+	return q.Do("EXPIREAT", key, timestamp)
+}
+
+// keys command
+func (q *cmdQueue) Keys(pattern string) *PipelineCmd {
+	return q.Do("KEYS", pattern)
+}
+
+// persist command
+func (q *cmdQueue) Persist(key string) *PipelineCmd {
+	return q.Do("PERSIST", key)
+}
+
+// ttl command
+func (q *cmdQueue) TTL(key string) *PipelineCmd {
+	return q.Do("TTL", key)
+}
+
+// setex command
+func (q *cmdQueue) Setex(key string, second int, value interface{}) *PipelineCmd {
+	return q.Do("SETEX", key, second, value)
+}
+
+// setnx command
+func (q *cmdQueue) Setnx(key string, value interface{}) *PipelineCmd {
+	return q.Do("SETNX", key, value)
+}
+
+// mset command
+func (q *cmdQueue) MSet(valueMap map[string]interface{}) *PipelineCmd {
+	return q.Do("MSET", redis.Args{}.AddFlat(valueMap)...)
+}
+
+// mget command
+func (q *cmdQueue) MGet(keys ...string) *PipelineCmd {
+	return q.Do("MGET", redis.Args{}.AddFlat(keys)...)
+}
+
+// decr command
+func (q *cmdQueue) Decr(key string) *PipelineCmd {
+	return q.Do("DECR", key)
+}
+
+// decrby command
+func (q *cmdQueue) DecrBy(key string, decrNum int) *PipelineCmd {
+	return q.Do("DECRBY", key, decrNum)
+}
+
+// incr command
+func (q *cmdQueue) Incr(key string) *PipelineCmd {
+	return q.Do("INCR", key)
+}
+
+// incrby command
+func (q *cmdQueue) IncrBy(key string, incrNum int) *PipelineCmd {
+	return q.Do("INCRBY", key, incrNum)
+}
+
+// getset command
+func (q *cmdQueue) GetSet(key string, value interface{}) *PipelineCmd {
+	return q.Do("GETSET", key, value)
+}
+
+// hdel command
+func (q *cmdQueue) HDel(key string, fields ...string) *PipelineCmd {
+	return q.Do("HDEL", redis.Args{}.Add(key).AddFlat(fields)...)
+}
+
+// hexists command
+func (q *cmdQueue) HExists(key, field string) *PipelineCmd {
+	return q.Do("HEXISTS", key, field)
+}
+
+// hset command
+func (q *cmdQueue) HSet(key, field string, value interface{}) *PipelineCmd {
+	return q.Do("HSET", key, field, value)
+}
+
+// hmset command
+func (q *cmdQueue) HMset(key string, values interface{}) *PipelineCmd {
+	return q.Do("HMSET", redis.Args{}.Add(key).AddFlat(values)...)
+}
+
+// hget command
+func (q *cmdQueue) HGet(key, field string) *PipelineCmd {
+	return q.Do("HGET", key, field)
+}
+
+// hgetall command
+func (q *cmdQueue) HGetAll(key string) *PipelineCmd {
+	return q.Do("HGETALL", key)
+}
+
+// hkeys command
+func (q *cmdQueue) HKeys(key string) *PipelineCmd {
+	return q.Do("HKEYS", key)
+}
+
+// hvals command
+func (q *cmdQueue) HVals(key string) *PipelineCmd {
+	return q.Do("HVALS", key)
+}
+
+// hlen command
+func (q *cmdQueue) HLen(key string) *PipelineCmd {
+	return q.Do("HLEN", key)
+}
+
+// hmget command
+func (q *cmdQueue) HMget(key string, fields ...string) *PipelineCmd {
+	return q.Do("HMGET", redis.Args{}.Add(key).AddFlat(fields)...)
+}
@@ -0,0 +1,117 @@
+package goredis
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	testInit()
+	p := NewHelper().Pipeline()
+	p.Set("pipeline_name", "scofield")
+	p.Incr("pipeline_counter")
+	get := p.Get("pipeline_name")
+	bad := p.HGet("pipeline_name", "field")
+	p.Del("pipeline_name", "pipeline_counter")
+	if p.Len() != 5 {
+		t.Error("want 5 commands, get ", p.Len())
+		return
+	}
+
+	cmds, err := p.Exec()
+	if err == nil {
+		t.Error("want WRONGTYPE error, get nil")
+	}
+	if len(cmds) != 5 || p.Len() != 0 {
+		t.Error("want 5 commands executed and pipeline reset, get ", len(cmds), p.Len())
+		return
+	}
+	if name, err := get.String(); err != nil || name != "scofield" {
+		t.Error("want scofield, get ", name, err)
+	}
+	if bad.Err() == nil {
+		t.Error("want HGET on string key fail")
+	}
+	if _, err := cmds[4].Int64(); err != nil {
+		t.Error(err.Error())
+	}
+}
+
+func TestPipelineChunk(t *testing.T) {
+	testInit()
+	p := NewHelper().Pipeline().SetChunkSize(7)
+	incrs := make([]*PipelineCmd, 0, 50)
+	for i := 0; i < 50; i++ {
+		incrs = append(incrs, p.Incr("pipeline_chunk"))
+	}
+	p.Del("pipeline_chunk")
+	if _, err := p.Exec(); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	for i, cmd := range incrs {
+		if value, err := cmd.Int64(); err != nil || value != int64(i+1) {
+			t.Error("want ", i+1, ", get ", value, err)
+			return
+		}
+	}
+}
+
+func TestPipelineContext(t *testing.T) {
+	testInit()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	p := NewHelper().WithContext(ctx).Pipeline()
+	ping := p.Do("PING")
+	if _, err := p.Exec(); err != nil {
+		t.Error(err.Error())
+	} else if pong, _ := ping.String(); pong != "PONG" {
+		t.Error("want PONG, get ", pong)
+	}
+
+	cancel()
+	p.Do("PING")
+	if _, err := p.Exec(); err != context.Canceled {
+		t.Error("want context canceled, get ", err)
+	}
+
+	p = NewHelper("not_exist").Pipeline()
+	p.Get("name")
+	if _, err := p.Exec(); err != ErrNodeNotFound {
+		t.Error("want ErrNodeNotFound, get ", err)
+	}
+}
+
+// 按顺序返回replies的连接，replies用完后返回连接错误
+type replyConn struct {
+	redis.Conn
+	replies []interface{}
+}
+
+func (c *replyConn) Send(string, ...interface{}) error { return nil }
+func (c *replyConn) Flush() error                      { return nil }
+func (c *replyConn) Close() error                      { return nil }
+func (c *replyConn) Receive() (interface{}, error) {
+	if len(c.replies) == 0 {
+		return nil, io.EOF
+	}
+	reply := c.replies[0]
+	c.replies = c.replies[1:]
+	return reply, nil
+}
+
+func TestPipelineConnError(t *testing.T) {
+	p := &Pipeline{chunkSize: DefaultPipelineChunkSize}
+	cmds := []*PipelineCmd{{command: "GET"}, {command: "GET"}, {command: "GET"}}
+	p.execConn(context.Background(), &replyConn{replies: []interface{}{nil, []byte("v")}}, cmds)
+	// 连接出错之前收到的nil结果不是错误
+	if cmds[0].reply != nil || cmds[0].err != nil || cmds[1].err != nil {
+		t.Error("want received replies kept, get ", cmds[0], cmds[1])
+	}
+	if cmds[2].err != io.EOF {
+		t.Error("want io.EOF, get ", cmds[2].err)
+	}
+}
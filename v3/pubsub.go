@@ -0,0 +1,310 @@
+package goredis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"sync"
+	"time"
+)
+
+var (
+	// 订阅连接发送PING检测连接是否可用的间隔，超过两个间隔没有收到任何数据时重连
+	SubscribePingInterval = time.Second * 30
+
+	// Subscriber已经关闭
+	ErrSubscriberClosed = errors.New("redis: subscriber closed")
+)
+
+const (
+	// 订阅连接断开后重连的等待时间
+	subscribeRetryInterval = time.Second
+	// 消息channel的缓冲大小
+	subscribeChannelSize = 100
+)
+
+// 订阅消息，Pattern只有通过PSubscribe订阅时才有值
+type Message = redis.Message
+
+// 订阅者，使用一个独立的连接订阅channel和pattern，连接断开后自动重连并重新订阅
+// 消息通过Channel获取，或者通过SetHandler设置的回调处理
+type Subscriber struct {
+	node *Node
+
+	mu       sync.Mutex
+	channels map[string]bool
+	patterns map[string]bool
+	handler  func(Message)
+	// 当前的订阅连接，重连期间为nil
+	conn   *redis.PubSubConn
+	closed bool
+
+	messages chan Message
+	wakeup   chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// 发布消息，返回收到消息的订阅者数量
+func (h *Helper) Publish(channel string, message interface{}) (receivers int64, err error) {
+	receivers, err = redis.Int64(h.command("PUBLISH", channel, message))
+	return
+}
+
+// 新建helper绑定节点上的订阅者
+func (h *Helper) Subscriber() (s *Subscriber, err error) {
+	var node *Node
+	if node, err = h.pool.GetNode(h.nodeName); err != nil {
+		return
+	}
+	s = node.Subscriber()
+	return
+}
+
+// 新建节点上的订阅者，订阅连接在第一次订阅时建立
+// 集群节点会在任意一个master上订阅，集群会将PUBLISH的消息广播到所有节点
+func (n *Node) Subscriber() *Subscriber {
+	s := &Subscriber{
+		node:     n,
+		channels: make(map[string]bool),
+		patterns: make(map[string]bool),
+		messages: make(chan Message, subscribeChannelSize),
+		wakeup:   make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// 建立订阅使用的连接，订阅连接不占用连接池
+func (n *Node) dialSubscriber() (redis.Conn, error) {
+	if cluster := n.getCluster(); cluster != nil {
+		addr, err := cluster.randomAddr()
+		if err != nil {
+			return nil, err
+		}
+		return dialAddr(addr, cluster.password, 0, cluster.config)
+	}
+	return n.GetPool().Dial()
+}
+
+// 获取接收消息的channel，Subscriber关闭后channel会被关闭
+// 设置了回调时消息不会发送到channel中
+func (s *Subscriber) Channel() <-chan Message {
+	return s.messages
+}
+
+// 设置处理消息的回调，回调在接收消息的goroutine中依次调用，不应长时间阻塞
+func (s *Subscriber) SetHandler(handler func(Message)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+// 订阅channel
+func (s *Subscriber) Subscribe(channels ...string) error {
+	return s.change(s.channels, true, channels, redis.PubSubConn.Subscribe)
+}
+
+// 按照pattern订阅
+func (s *Subscriber) PSubscribe(patterns ...string) error {
+	return s.change(s.patterns, true, patterns, redis.PubSubConn.PSubscribe)
+}
+
+// 取消订阅channel
+func (s *Subscriber) Unsubscribe(channels ...string) error {
+	return s.change(s.channels, false, channels, redis.PubSubConn.Unsubscribe)
+}
+
+// 取消按照pattern的订阅
+func (s *Subscriber) PUnsubscribe(patterns ...string) error {
+	return s.change(s.patterns, false, patterns, redis.PubSubConn.PUnsubscribe)
+}
+
+// 修改订阅集合，连接可用时立即发送订阅命令，否则在连接建立后统一订阅
+// 发送失败时连接会被重建，重建后按照最新的订阅集合重新订阅，因此不返回发送的错误
+func (s *Subscriber) change(set map[string]bool, add bool, names []string, send func(redis.PubSubConn, ...interface{}) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSubscriberClosed
+	}
+
+	for _, name := range names {
+		if add {
+			set[name] = true
+		} else {
+			delete(set, name)
+		}
+	}
+	if s.conn != nil && len(names) > 0 {
+		if err := send(*s.conn, redis.Args{}.AddFlat(names)...); err != nil {
+			s.conn.Close()
+		}
+	}
+
+	// 通知后台goroutine可能需要建立连接
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// 关闭订阅者，关闭订阅连接和消息channel
+func (s *Subscriber) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stop)
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.mu.Unlock()
+
+	<-s.done
+	return nil
+}
+
+// 维持订阅连接，连接断开后等待subscribeRetryInterval重连
+func (s *Subscriber) run() {
+	defer close(s.done)
+	defer close(s.messages)
+
+	for {
+		s.mu.Lock()
+		idle := len(s.channels) == 0 && len(s.patterns) == 0
+		s.mu.Unlock()
+		if idle {
+			// 没有任何订阅时不建立连接
+			select {
+			case <-s.stop:
+				return
+			case <-s.wakeup:
+				continue
+			}
+		}
+
+		if err := s.receive(); err != nil {
+			fmt.Printf("[warning][redis] subscriber receive fail: %s\n", err.Error())
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(subscribeRetryInterval):
+		}
+	}
+}
+
+// 建立连接并订阅当前所有的channel和pattern，然后接收消息直到连接出错或者订阅者关闭
+func (s *Subscriber) receive() error {
+	conn, err := s.node.dialSubscriber()
+	if err != nil {
+		return err
+	}
+	psc := &redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	channels, patterns := setKeys(s.channels), setKeys(s.patterns)
+	if len(channels) > 0 {
+		err = psc.Subscribe(redis.Args{}.AddFlat(channels)...)
+	}
+	if err == nil && len(patterns) > 0 {
+		err = psc.PSubscribe(redis.Args{}.AddFlat(patterns)...)
+	}
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.conn = psc
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+
+	pingDone := make(chan struct{})
+	defer close(pingDone)
+	go s.ping(psc, pingDone)
+
+	for {
+		switch v := psc.ReceiveWithTimeout(SubscribePingInterval * 2).(type) {
+		case redis.Message:
+			s.deliver(v)
+		case redis.Subscription:
+			if v.Count == 0 && v.Kind != "subscribe" && v.Kind != "psubscribe" {
+				// 所有订阅都已经取消，关闭连接，有新的订阅时再重连
+				s.mu.Lock()
+				idle := len(s.channels) == 0 && len(s.patterns) == 0
+				s.mu.Unlock()
+				if idle {
+					return nil
+				}
+			}
+		case error:
+			select {
+			case <-s.stop:
+				return nil
+			default:
+			}
+			return v
+		}
+	}
+}
+
+// 定时在订阅连接上发送PING，收到的PONG会重置接收的超时时间
+func (s *Subscriber) ping(psc *redis.PubSubConn, done chan struct{}) {
+	ticker := time.NewTicker(SubscribePingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		err := psc.Ping("")
+		s.mu.Unlock()
+		if err != nil {
+			psc.Close()
+			return
+		}
+	}
+}
+
+// 将消息交给回调或者发送到channel，channel已满时等待直到订阅者关闭
+func (s *Subscriber) deliver(msg Message) {
+	s.mu.Lock()
+	handler := s.handler
+	s.mu.Unlock()
+	if handler != nil {
+		handler(msg)
+		return
+	}
+
+	select {
+	case s.messages <- msg:
+	case <-s.stop:
+	}
+}
+
+// 获取集合中的所有元素
+func setKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,104 @@
+package goredis
+
+import (
+	"testing"
+	"time"
+)
+
+// 等待订阅生效后发布消息
+func publishUntilReceived(t *testing.T, channel, message string) bool {
+	for i := 0; i < 50; i++ {
+		receivers, err := NewHelper().Publish(channel, message)
+		if err != nil {
+			t.Error(err.Error())
+			return false
+		}
+		if receivers > 0 {
+			return true
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	t.Error("no subscriber received ", message)
+	return false
+}
+
+func TestSubscriber(t *testing.T) {
+	testInit()
+	s, err := NewHelper().Subscriber()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer s.Close()
+
+	if err = s.Subscribe("pubsub_channel"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if !publishUntilReceived(t, "pubsub_channel", "hello") {
+		return
+	}
+	select {
+	case msg := <-s.Channel():
+		if msg.Channel != "pubsub_channel" || string(msg.Data) != "hello" {
+			t.Error("want pubsub_channel hello, get ", msg.Channel, string(msg.Data))
+		}
+	case <-time.After(time.Second * 3):
+		t.Error("wait message timeout")
+		return
+	}
+
+	// 模拟网络断开，订阅者应当自动重连并重新订阅
+	s.mu.Lock()
+	s.conn.Close()
+	s.mu.Unlock()
+	if !publishUntilReceived(t, "pubsub_channel", "again") {
+		return
+	}
+	select {
+	case msg := <-s.Channel():
+		if string(msg.Data) != "again" {
+			t.Error("want again, get ", string(msg.Data))
+		}
+	case <-time.After(time.Second * 3):
+		t.Error("wait message after reconnect timeout")
+	}
+
+	s.Close()
+	if _, ok := <-s.Channel(); ok {
+		t.Error("want channel closed")
+	}
+	if err = s.Subscribe("pubsub_channel"); err != ErrSubscriberClosed {
+		t.Error("want ErrSubscriberClosed, get ", err)
+	}
+}
+
+func TestSubscriberHandler(t *testing.T) {
+	testInit()
+	s, err := NewHelper().Subscriber()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer s.Close()
+
+	received := make(chan Message, 1)
+	s.SetHandler(func(msg Message) {
+		received <- msg
+	})
+	if err = s.PSubscribe("pubsub_pattern_*"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if !publishUntilReceived(t, "pubsub_pattern_1", "world") {
+		return
+	}
+	select {
+	case msg := <-received:
+		if msg.Pattern != "pubsub_pattern_*" || msg.Channel != "pubsub_pattern_1" || string(msg.Data) != "world" {
+			t.Error("unexpected message ", msg.Pattern, msg.Channel, string(msg.Data))
+		}
+	case <-time.After(time.Second * 3):
+		t.Error("wait message timeout")
+	}
+}
@@ -0,0 +1,43 @@
+package goredis
+
+import "strings"
+
+// 只读命令表，Helper开启ReadFromReplicas后这些命令会被路由到slave执行
+var readOnlyCommands = map[string]bool{
+	// keys
+	"DUMP": true, "EXISTS": true, "KEYS": true, "OBJECT": true, "PTTL": true,
+	"RANDOMKEY": true, "SCAN": true, "SORT_RO": true, "TTL": true, "TYPE": true,
+	"DBSIZE": true,
+	// strings
+	"BITCOUNT": true, "BITPOS": true, "GET": true, "GETBIT": true, "GETRANGE": true,
+	"MGET": true, "STRLEN": true,
+	// hashes
+	"HEXISTS": true, "HGET": true, "HGETALL": true, "HKEYS": true, "HLEN": true,
+	"HMGET": true, "HRANDFIELD": true, "HSCAN": true, "HSTRLEN": true, "HVALS": true,
+	// lists
+	"LINDEX": true, "LLEN": true, "LPOS": true, "LRANGE": true,
+	// sets
+	"SCARD": true, "SDIFF": true, "SINTER": true, "SINTERCARD": true, "SISMEMBER": true,
+	"SMEMBERS": true, "SMISMEMBER": true, "SRANDMEMBER": true, "SSCAN": true, "SUNION": true,
+	// sorted sets
+	"ZCARD": true, "ZCOUNT": true, "ZDIFF": true, "ZINTER": true, "ZINTERCARD": true,
+	"ZLEXCOUNT": true, "ZMSCORE": true, "ZRANDMEMBER": true, "ZRANGE": true,
+	"ZRANGEBYLEX": true, "ZRANGEBYSCORE": true, "ZRANK": true, "ZREVRANGE": true,
+	"ZREVRANGEBYLEX": true, "ZREVRANGEBYSCORE": true, "ZREVRANK": true, "ZSCAN": true,
+	"ZSCORE": true, "ZUNION": true,
+	// streams
+	"XINFO": true, "XLEN": true, "XPENDING": true, "XRANGE": true, "XREAD": true,
+	"XREVRANGE": true,
+	// geo
+	"GEODIST": true, "GEOHASH": true, "GEOPOS": true, "GEORADIUS_RO": true,
+	"GEORADIUSBYMEMBER_RO": true, "GEOSEARCH": true,
+	// hyperloglog
+	"PFCOUNT": true,
+	// scripting
+	"EVAL_RO": true, "EVALSHA_RO": true, "FCALL_RO": true,
+}
+
+// 判断命令是否为只读命令，只读命令可以在slave上执行
+func IsReadOnlyCommand(command string) bool {
+	return readOnlyCommands[strings.ToUpper(command)]
+}
@@ -0,0 +1,156 @@
+package goredis
+
+import (
+	"context"
+	"errors"
+	"github.com/vaughan0/go-ini"
+	"os"
+	"sort"
+	"time"
+)
+
+// 重新加载节点配置的结果
+type ReloadResult struct {
+	// 新增的节点
+	Added []string
+	// 被移除的节点，连接池会在连接归还后关闭
+	Removed []string
+	// 连接方式，连接池配置或者slave设置发生变化的节点
+	Changed []string
+}
+
+var (
+	// 重新加载的配置中没有任何节点
+	ErrNoNodes = errors.New("no redis node found in config")
+	// 监听配置文件的检查间隔小于等于0
+	ErrInvalidInterval = errors.New("watch interval must be positive")
+)
+
+// 判断重新加载是否有节点发生变化
+func (r ReloadResult) HasChanges() bool {
+	return len(r.Added) > 0 || len(r.Removed) > 0 || len(r.Changed) > 0
+}
+
+// 重新加载默认Pool的节点配置，见Pool.Reload
+func Reload(nodeConfig ini.File) (ReloadResult, error) {
+	return pool.Reload(nodeConfig)
+}
+
+// 根据新的ini配置重新加载节点，ini配置会被当作节点的完整定义：
+// 新增的节点会被创建，配置中已经不存在的节点会被移除，连接方式或连接池配置发生变化的节点会重建连接池，
+// 只有slave设置发生变化的节点只更新slave设置，没有变化的节点不受影响
+// 如果新的配置中没有任何节点，那么不做任何修改并返回ErrNoNodes
+func (p *Pool) Reload(nodeConfig ini.File) (result ReloadResult, err error) {
+	definitions := parseNodeDefinitions(nodeConfig, p.Config())
+	if len(definitions) == 0 {
+		err = ErrNoNodes
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for nodeName, node := range p.nodes {
+		if _, exist := definitions[nodeName]; !exist {
+			delete(p.nodes, nodeName)
+			node.resources().drain()
+			result.Removed = append(result.Removed, nodeName)
+		}
+	}
+	for nodeName, definition := range definitions {
+		node, exist := p.nodes[nodeName]
+		if !exist {
+			p.setNode(nodeName, definition)
+			p.nodes[nodeName].setRouting(definition)
+			result.Added = append(result.Added, nodeName)
+			continue
+		}
+
+		node.mu.RLock()
+		poolChanged := !node.definition.samePool(definition)
+		slavesChanged := !node.definition.sameRouting(definition)
+		node.mu.RUnlock()
+
+		if poolChanged {
+			p.setNode(nodeName, definition)
+		}
+		if slavesChanged {
+			node.setRouting(definition)
+		}
+		if poolChanged || slavesChanged {
+			result.Changed = append(result.Changed, nodeName)
+		}
+	}
+
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+	sort.Strings(result.Changed)
+	return
+}
+
+// 监听默认Pool的配置文件，见Pool.WatchFile
+func WatchFile(ctx context.Context, filename string, interval time.Duration, callback func(ReloadResult, error)) error {
+	return pool.WatchFile(ctx, filename, interval, callback)
+}
+
+// 每隔interval检查一次ini配置文件，文件的修改时间或大小发生变化时重新加载节点配置，
+// 每次重新加载后调用callback（callback可以为nil），ctx结束时返回ctx.Err()，interval小于等于0时返回ErrInvalidInterval
+// 该方法会一直阻塞，一般在单独的goroutine中调用
+func (p *Pool) WatchFile(ctx context.Context, filename string, interval time.Duration, callback func(ReloadResult, error)) error {
+	var (
+		lastModTime time.Time
+		lastSize    int64
+	)
+
+	if interval <= 0 {
+		return ErrInvalidInterval
+	}
+
+	if info, err := os.Stat(filename); err == nil {
+		lastModTime, lastSize = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(filename)
+		if err != nil {
+			if callback != nil {
+				callback(ReloadResult{}, err)
+			}
+			continue
+		}
+		if info.ModTime().Equal(lastModTime) && info.Size() == lastSize {
+			continue
+		}
+		lastModTime, lastSize = info.ModTime(), info.Size()
+
+		var result ReloadResult
+		nodeConfig, err := ini.LoadFile(filename)
+		if err == nil {
+			result, err = p.Reload(nodeConfig)
+		}
+		if callback != nil {
+			callback(result, err)
+		}
+	}
+}
+
+// 判断两个字符串切片的内容是否相同
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
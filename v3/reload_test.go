@@ -0,0 +1,112 @@
+package goredis
+
+import (
+	"context"
+	"github.com/vaughan0/go-ini"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReload(t *testing.T) {
+	p := NewPool(Config{})
+	defer p.Close()
+	p.LoadNodes(ini.File{
+		"redis_node_default": ini.Section{
+			"scheme": "redis://:@localhost:6379",
+			"slaves": "slave1",
+		},
+		"redis_node_slave1": ini.Section{
+			"scheme": "redis://:@localhost:6379",
+		},
+		"redis_node_cache": ini.Section{
+			"scheme": "redis://:@localhost:6379",
+		},
+	})
+	defaultNode, _ := p.GetNode(DefaultNodeName)
+	defaultPool := defaultNode.GetPool()
+
+	result, err := p.Reload(ini.File{
+		"redis_node_default": ini.Section{
+			"scheme": "redis://:@localhost:6379",
+		},
+		"redis_node_cache": ini.Section{
+			"scheme":     "redis://:@localhost:6379",
+			"max_active": "50",
+		},
+		"redis_node_queue": ini.Section{
+			"scheme": "redis://:@localhost:6379",
+		},
+	})
+	if err != nil {
+		t.Error("reload fail,err:", err.Error())
+		return
+	}
+	want := ReloadResult{
+		Added:   []string{"queue"},
+		Removed: []string{"slave1"},
+		Changed: []string{"cache", "default"},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("reload result wrong,want %v,get %v", want, result)
+	}
+	if defaultNode.GetPool() != defaultPool {
+		t.Error("default node pool should not be rebuilt when only slaves changed")
+	}
+	if _, err = defaultNode.GetSlave(); err != ErrNodeNotFound {
+		t.Error("want ErrNodeNotFound,get ", err)
+	}
+	if node, err := p.GetNode("cache"); err != nil || node.GetPool().MaxActive != 50 {
+		t.Error("cache node not reloaded")
+	}
+	if _, err = p.Command("queue", "PING"); err != nil {
+		t.Error("ping fail,err:", err.Error())
+	}
+
+	if _, err = p.Reload(ini.File{}); err != ErrNoNodes {
+		t.Error("want ErrNoNodes,get ", err)
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "goredis_*.ini")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("[redis_node_default]\nscheme=redis://:@localhost:6379\n")
+	f.Close()
+
+	p := NewPool(Config{})
+	defer p.Close()
+	nodeConfig, _ := ini.LoadFile(f.Name())
+	p.LoadNodes(nodeConfig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err = p.WatchFile(ctx, f.Name(), 0, nil); err != ErrInvalidInterval {
+		t.Error("want ErrInvalidInterval,get ", err)
+	}
+	results := make(chan ReloadResult, 1)
+	go p.WatchFile(ctx, f.Name(), time.Millisecond*10, func(result ReloadResult, err error) {
+		if err != nil {
+			t.Error("reload fail,err:", err.Error())
+			return
+		}
+		results <- result
+	})
+
+	time.Sleep(time.Millisecond * 50)
+	ioutil.WriteFile(f.Name(), []byte("[redis_node_default]\nscheme=redis://:@localhost:6379\n[redis_node_cache]\nscheme=redis://:@localhost:6379\n"), 0644)
+	select {
+	case result := <-results:
+		if len(result.Added) != 1 || result.Added[0] != "cache" {
+			t.Error("want cache added,get ", result)
+		}
+	case <-ctx.Done():
+		t.Error("config file change not detected")
+	}
+}
@@ -0,0 +1,601 @@
+package goredis
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// RESP2协议，默认使用
+	ProtocolRESP2 = 2
+	// RESP3协议，需要redis 6.0以上，连接后通过HELLO 3协商
+	ProtocolRESP3 = 3
+)
+
+// RESP3连接收到的push消息(如CLIENT TRACKING的invalidate)，订阅相关的push消息不会交给PushHandler，
+// 而是和RESP2一样作为回复返回，以便redis.PubSubConn使用
+type Push struct {
+	// 收到消息的连接地址
+	Addr string
+	// 消息类型，即push数据的第一个元素，如"invalidate"
+	Kind string
+	// 消息类型之后的数据
+	Data []interface{}
+}
+
+// 处理push消息，在读取回复的goroutine中同步调用，不能阻塞，也不能使用收到消息的连接
+type PushHandler func(push Push)
+
+// 节点配置共享的PushHandler，节点的连接在收到push消息时通过它找到当前的handler
+type pushHandlers struct {
+	handler atomic.Value
+}
+
+// 将push消息交给当前的handler，没有设置handler时丢弃
+func (h *pushHandlers) handle(push Push) {
+	if h == nil {
+		return
+	}
+	if handler, ok := h.handler.Load().(PushHandler); ok && handler != nil {
+		handler(push)
+	}
+}
+
+// 设置默认Pool的PushHandler，见Pool.SetPushHandler
+func SetPushHandler(handler PushHandler) {
+	pool.SetPushHandler(handler)
+}
+
+// 设置p中所有使用RESP3的节点收到push消息时的处理函数，对已经建立的连接立即生效
+func (p *Pool) SetPushHandler(handler PushHandler) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	p.config.push.handler.Store(handler)
+}
+
+// 判断是否为订阅相关的push消息
+var pubSubPushKinds = map[string]bool{
+	"message": true, "pmessage": true, "smessage": true,
+	"subscribe": true, "psubscribe": true, "ssubscribe": true,
+	"unsubscribe": true, "punsubscribe": true, "sunsubscribe": true,
+}
+
+// RESP3中的push帧，只在读取时使用
+type resp3Push []interface{}
+
+// 使用RESP3协议的连接，实现了redis.Conn和redis.ConnWithTimeout
+// 回复的类型：map为map[string]interface{}，set为map[string]struct{}，double为float64，大数为*big.Int，
+// boolean为bool，null为nil，verbatim string为[]byte(不含格式前缀)，其他类型与redigo相同
+type resp3Conn struct {
+	addr string
+	push *pushHandlers
+
+	mu      sync.Mutex
+	pending int
+	err     error
+	conn    net.Conn
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	br           *bufio.Reader
+	bw           *bufio.Writer
+
+	// 是否处于订阅状态，订阅状态下PING的回复会转换为RESP2的pong格式
+	subscribed bool
+}
+
+// 通过url连接redis并使用RESP3协议，url格式与redis.DialURL相同
+func dialURLRESP3(rawurl string, nodeConf Config) (redis.Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("invalid redis URL scheme: %s", u.Scheme)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host, port = u.Host, "6379"
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	var username, password string
+	if u.User != nil {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	db := 0
+	if path := strings.TrimPrefix(u.Path, "/"); path != "" {
+		if db, err = strconv.Atoi(path); err != nil {
+			return nil, fmt.Errorf("invalid database: %s", path)
+		}
+	}
+	return dialRESP3(net.JoinHostPort(host, port), u.Scheme == "rediss", username, password, db, nodeConf)
+}
+
+// 连接addr并通过HELLO 3协商RESP3协议，同时完成认证和设置连接名称
+func dialRESP3(addr string, useTLS bool, username, password string, db int, nodeConf Config) (redis.Conn, error) {
+	dialer := net.Dialer{Timeout: nodeConf.connectTimeout(), KeepAlive: time.Minute * 5}
+	var (
+		netConn net.Conn
+		err     error
+	)
+	if useTLS {
+		host, _, _ := net.SplitHostPort(addr)
+		netConn, err = tls.DialWithDialer(&dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		netConn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &resp3Conn{
+		addr:         addr,
+		push:         nodeConf.push,
+		conn:         netConn,
+		readTimeout:  nodeConf.readTimeout(),
+		writeTimeout: nodeConf.writeTimeout(),
+		br:           bufio.NewReader(netConn),
+		bw:           bufio.NewWriter(netConn),
+	}
+	args := redis.Args{}.Add(ProtocolRESP3)
+	if password != "" {
+		if username == "" {
+			username = "default"
+		}
+		args = args.Add("AUTH", username, password)
+	}
+	if nodeConf.ClientName != "" {
+		args = args.Add("SETNAME", nodeConf.ClientName)
+	}
+	if _, err = c.Do("HELLO", args...); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if db != 0 {
+		if _, err = c.Do("SELECT", db); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *resp3Conn) Close() error {
+	c.mu.Lock()
+	err := c.err
+	if c.err == nil {
+		c.err = errors.New("redigo: closed")
+		err = c.conn.Close()
+	}
+	c.mu.Unlock()
+	return err
+}
+
+// 连接出错后关闭连接，之后的所有操作都返回该错误
+func (c *resp3Conn) fatal(err error) error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+		c.conn.Close()
+	}
+	c.mu.Unlock()
+	return err
+}
+
+func (c *resp3Conn) Err() error {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	return err
+}
+
+func (c *resp3Conn) Do(command string, args ...interface{}) (interface{}, error) {
+	if handleNetConnCommand(c.conn, command, args) {
+		return nil, nil
+	}
+	return c.do(c.readTimeout, 0, command, args...)
+}
+
+// 超时时间同时作为写入截止时间的上限，与deadlineConn一致
+func (c *resp3Conn) DoWithTimeout(readTimeout time.Duration, command string, args ...interface{}) (interface{}, error) {
+	return c.do(readTimeout, readTimeout, command, args...)
+}
+
+// 发送命令并读取回复，writeLimit大于0时写入的截止时间不超过writeLimit
+func (c *resp3Conn) do(readTimeout, writeLimit time.Duration, command string, args ...interface{}) (interface{}, error) {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = 0
+	c.mu.Unlock()
+
+	if command == "" && pending == 0 {
+		return nil, nil
+	}
+	c.setWriteDeadline(writeLimit)
+	if command != "" {
+		c.writeCommand(command, args)
+	}
+	if err := c.bw.Flush(); err != nil {
+		return nil, c.fatal(err)
+	}
+	c.setReadDeadline(readTimeout)
+
+	if command == "" {
+		replies := make([]interface{}, pending)
+		for i := range replies {
+			reply, err := c.readReply()
+			if err != nil {
+				return nil, c.fatal(err)
+			}
+			replies[i] = reply
+		}
+		return replies, nil
+	}
+
+	var (
+		reply interface{}
+		err   error
+	)
+	for i := 0; i <= pending; i++ {
+		var readErr error
+		if reply, readErr = c.readReply(); readErr != nil {
+			return nil, c.fatal(readErr)
+		}
+		if replyErr, ok := reply.(redis.Error); ok && err == nil {
+			err = replyErr
+		}
+	}
+	return reply, err
+}
+
+func (c *resp3Conn) Send(command string, args ...interface{}) error {
+	c.mu.Lock()
+	c.pending++
+	c.mu.Unlock()
+	c.setWriteDeadline(0)
+	c.writeCommand(command, args)
+	return nil
+}
+
+func (c *resp3Conn) Flush() error {
+	c.setWriteDeadline(0)
+	if err := c.bw.Flush(); err != nil {
+		return c.fatal(err)
+	}
+	return nil
+}
+
+func (c *resp3Conn) Receive() (interface{}, error) {
+	return c.ReceiveWithTimeout(c.readTimeout)
+}
+
+func (c *resp3Conn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	c.setReadDeadline(timeout)
+	reply, err := c.readReply()
+	if err != nil {
+		return nil, c.fatal(err)
+	}
+	// 订阅状态下收到的push消息不对应任何Send，pending为0时不再减少
+	c.mu.Lock()
+	if c.pending > 0 {
+		c.pending--
+	}
+	c.mu.Unlock()
+	if replyErr, ok := reply.(redis.Error); ok {
+		return nil, replyErr
+	}
+	return reply, nil
+}
+
+// 设置写入的截止时间，使用连接的写超时时间，limit大于0时不超过limit，都为0时不超时
+func (c *resp3Conn) setWriteDeadline(limit time.Duration) {
+	timeout := c.writeTimeout
+	if limit > 0 && (timeout == 0 || limit < timeout) {
+		timeout = limit
+	}
+	var deadline time.Time
+	if timeout != 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	c.conn.SetWriteDeadline(deadline)
+}
+
+// 设置读取超时时间，为0时不超时
+func (c *resp3Conn) setReadDeadline(timeout time.Duration) {
+	var deadline time.Time
+	if timeout != 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	c.conn.SetReadDeadline(deadline)
+}
+
+// 按照RESP的数组格式写入命令，参数的转换规则与redigo相同
+// 写入的错误会保留在bufio.Writer中，在Flush时返回
+func (c *resp3Conn) writeCommand(command string, args []interface{}) {
+	c.writeHeader('*', int64(len(args)+1))
+	c.writeBytes([]byte(command))
+	for _, arg := range args {
+		c.writeBytes(argBytes(redisArg(arg)))
+	}
+}
+
+// 转换实现了redis.Argument的参数，bool转换为1/0，nil转换为空字符串
+func redisArg(arg interface{}) interface{} {
+	switch v := arg.(type) {
+	case redis.Argument:
+		return v.RedisArg()
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case nil:
+		return ""
+	}
+	return arg
+}
+
+func (c *resp3Conn) writeHeader(prefix byte, n int64) {
+	c.bw.WriteByte(prefix)
+	c.bw.WriteString(strconv.FormatInt(n, 10))
+	c.bw.WriteString("\r\n")
+}
+
+func (c *resp3Conn) writeBytes(p []byte) {
+	c.writeHeader('$', int64(len(p)))
+	c.bw.Write(p)
+	c.bw.WriteString("\r\n")
+}
+
+// 读取一个回复，非订阅相关的push消息交给PushHandler处理后继续读取
+func (c *resp3Conn) readReply() (interface{}, error) {
+	for {
+		reply, err := c.readValue()
+		if err != nil {
+			return nil, err
+		}
+		push, ok := reply.(resp3Push)
+		if !ok {
+			if c.subscribed {
+				return pongReply(reply), nil
+			}
+			return reply, nil
+		}
+		if len(push) == 0 {
+			continue
+		}
+
+		kind, _ := redis.String(push[0], nil)
+		if pubSubPushKinds[kind] {
+			if strings.HasSuffix(kind, "subscribe") && len(push) == 3 {
+				count, _ := push[2].(int64)
+				c.subscribed = count > 0
+			}
+			return []interface{}(push), nil
+		}
+		c.push.handle(Push{Addr: c.addr, Kind: kind, Data: push[1:]})
+	}
+}
+
+// 订阅状态下RESP3的PING回复为普通的字符串，转换为RESP2订阅状态下的["pong", data]格式
+func pongReply(reply interface{}) interface{} {
+	switch v := reply.(type) {
+	case string:
+		if v == "PONG" {
+			return []interface{}{[]byte("pong"), []byte("")}
+		}
+	case []byte:
+		return []interface{}{[]byte("pong"), v}
+	}
+	return reply
+}
+
+// 读取以\r\n结尾的一行，不包含\r\n
+func (c *resp3Conn) readLine() ([]byte, error) {
+	p, err := c.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		buf := append([]byte(nil), p...)
+		for err == bufio.ErrBufferFull {
+			p, err = c.br.ReadSlice('\n')
+			buf = append(buf, p...)
+		}
+		p = buf
+	}
+	if err != nil {
+		return nil, err
+	}
+	i := len(p) - 2
+	if i < 0 || p[i] != '\r' {
+		return nil, errors.New("redigo: bad response line terminator")
+	}
+	return p[:i], nil
+}
+
+// 读取长度为n的数据以及结尾的\r\n
+func (c *resp3Conn) readBlob(n int64) ([]byte, error) {
+	p := make([]byte, n+2)
+	if _, err := io.ReadFull(c.br, p); err != nil {
+		return nil, err
+	}
+	if p[n] != '\r' || p[n+1] != '\n' {
+		return nil, errors.New("redigo: bad bulk string format")
+	}
+	return p[:n], nil
+}
+
+// 读取一个RESP3的值
+func (c *resp3Conn) readValue() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redigo: short response line")
+	}
+	prefix, body := line[0], string(line[1:])
+	switch prefix {
+	case '+':
+		return body, nil
+	case '-':
+		return redis.Error(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '_':
+		return nil, nil
+	case ',':
+		return parseDouble(body)
+	case '#':
+		return body == "t", nil
+	case '(':
+		n, ok := new(big.Int).SetString(body, 10)
+		if !ok {
+			return nil, fmt.Errorf("redigo: invalid big number %q", body)
+		}
+		return n, nil
+	case '$', '!', '=':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		blob, err := c.readBlob(n)
+		if err != nil {
+			return nil, err
+		}
+		switch prefix {
+		case '!':
+			return redis.Error(blob), nil
+		case '=':
+			// verbatim string的前4个字节为格式，如"txt:"
+			if len(blob) >= 4 {
+				blob = blob[4:]
+			}
+		}
+		return blob, nil
+	case '*', '~', '>', '%', '|':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		if prefix == '%' || prefix == '|' {
+			n *= 2
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = c.readValue(); err != nil {
+				return nil, err
+			}
+		}
+		switch prefix {
+		case '~':
+			set := make(map[string]struct{}, len(values))
+			for _, value := range values {
+				set[mapKey(value)] = struct{}{}
+			}
+			return set, nil
+		case '>':
+			return resp3Push(values), nil
+		case '|':
+			// attribute是附加在下一个回复之前的元信息，忽略后读取真正的回复
+			return c.readValue()
+		}
+		if prefix == '%' {
+			m := make(map[string]interface{}, len(values)/2)
+			for i := 0; i < len(values); i += 2 {
+				m[mapKey(values[i])] = values[i+1]
+			}
+			return m, nil
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("redigo: unexpected response line %q", line)
+}
+
+// 将map的key和set的元素转换为string
+func mapKey(value interface{}) string {
+	switch v := value.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// 解析RESP3的double，支持inf，-inf和nan
+func parseDouble(s string) (float64, error) {
+	switch s {
+	case "inf":
+		s = "+Inf"
+	case "-inf":
+		s = "-Inf"
+	case "nan":
+		s = "NaN"
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// 将RESP3特有的类型转换为RESP2中对应的形式，Helper，Pipeline和Tx的结果都会经过转换，使得两种协议下的结果一致：
+// map转换为key-value交替的数组，set转换为数组，double和大数转换为字符串，boolean转换为1/0
+func resp2Reply(reply interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return reply, err
+	}
+	return toRESP2(reply), nil
+}
+
+// 递归地转换RESP3的值，见resp2Reply
+func toRESP2(reply interface{}) interface{} {
+	switch v := reply.(type) {
+	case []interface{}:
+		for i := range v {
+			v[i] = toRESP2(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		values := make([]interface{}, 0, len(v)*2)
+		for key, value := range v {
+			values = append(values, []byte(key), toRESP2(value))
+		}
+		return values
+	case map[string]struct{}:
+		values := make([]interface{}, 0, len(v))
+		for member := range v {
+			values = append(values, []byte(member))
+		}
+		return values
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case *big.Int:
+		return []byte(v.String())
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	return reply
+}
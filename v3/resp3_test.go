@@ -0,0 +1,282 @@
+package goredis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"github.com/vaughan0/go-ini"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 新建从data中读取回复的RESP3连接
+func newTestRESP3Conn(data string, push *pushHandlers) *resp3Conn {
+	client, server := net.Pipe()
+	go func() {
+		server.Write([]byte(data))
+		server.Close()
+	}()
+	return &resp3Conn{addr: "test", push: push, conn: client, br: bufio.NewReader(client), bw: bufio.NewWriter(client)}
+}
+
+func TestRESP3Decode(t *testing.T) {
+	c := newTestRESP3Conn("%2\r\n+name\r\n$3\r\nfoo\r\n+tags\r\n~2\r\n$1\r\na\r\n$1\r\nb\r\n"+
+		",1.5\r\n,-inf\r\n#t\r\n(3492890328409238509324850943850943825024385\r\n_\r\n"+
+		"=15\r\ntxt:Some string\r\n!9\r\nERR fails\r\n|1\r\n+ttl\r\n:3600\r\n:42\r\n", nil)
+
+	reply, err := c.readReply()
+	m, ok := reply.(map[string]interface{})
+	if err != nil || !ok || string(m["name"].([]byte)) != "foo" {
+		t.Error("want map, get ", reply, err)
+		return
+	}
+	if tags, ok := m["tags"].(map[string]struct{}); !ok || len(tags) != 2 {
+		t.Error("want set, get ", m["tags"])
+	}
+	if reply, _ = c.readReply(); reply != 1.5 {
+		t.Error("want 1.5, get ", reply)
+	}
+	if reply, _ = c.readReply(); reply != math.Inf(-1) {
+		t.Error("want -inf, get ", reply)
+	}
+	if reply, _ = c.readReply(); reply != true {
+		t.Error("want true, get ", reply)
+	}
+	want, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+	if reply, _ = c.readReply(); reply.(*big.Int).Cmp(want) != 0 {
+		t.Error("want big number, get ", reply)
+	}
+	if reply, _ = c.readReply(); reply != nil {
+		t.Error("want nil, get ", reply)
+	}
+	if s, _ := redis.String(c.readReply()); s != "Some string" {
+		t.Error("want verbatim string, get ", s)
+	}
+	if reply, _ = c.readReply(); reply != redis.Error("ERR fails") {
+		t.Error("want blob error, get ", reply)
+	}
+	// attribute被忽略
+	if reply, _ = c.readReply(); reply != int64(42) {
+		t.Error("want 42, get ", reply)
+	}
+}
+
+func TestRESP3Push(t *testing.T) {
+	push := &pushHandlers{}
+	var pushes []Push
+	push.handler.Store(PushHandler(func(p Push) {
+		pushes = append(pushes, p)
+	}))
+	c := newTestRESP3Conn(">2\r\n$10\r\ninvalidate\r\n*1\r\n$3\r\nkey\r\n+OK\r\n"+
+		">3\r\n$9\r\nsubscribe\r\n$2\r\nch\r\n:1\r\n+PONG\r\n>3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$2\r\nhi\r\n", push)
+
+	if reply, err := c.readReply(); reply != "OK" || err != nil {
+		t.Error("want OK, get ", reply, err)
+	}
+	if len(pushes) != 1 || pushes[0].Kind != "invalidate" || pushes[0].Addr != "test" || len(pushes[0].Data) != 1 {
+		t.Error("want invalidate push, get ", pushes)
+	}
+
+	// 订阅相关的push作为回复返回，订阅状态下的PONG转换为RESP2格式
+	psc := redis.PubSubConn{Conn: c}
+	if s, ok := psc.Receive().(redis.Subscription); !ok || s.Kind != "subscribe" || s.Count != 1 {
+		t.Error("want subscription, get ", s)
+	}
+	if _, ok := psc.Receive().(redis.Pong); !ok {
+		t.Error("want pong")
+	}
+	if msg, ok := psc.Receive().(redis.Message); !ok || msg.Channel != "ch" || string(msg.Data) != "hi" {
+		t.Error("want message, get ", msg)
+	}
+}
+
+func TestToRESP2(t *testing.T) {
+	reply := toRESP2([]interface{}{
+		map[string]interface{}{"a": 1.5},
+		map[string]struct{}{"m": {}},
+		true,
+		big.NewInt(7),
+	})
+	values, _ := redis.Values(reply, nil)
+	if m, err := redis.StringMap(values[0], nil); err != nil || m["a"] != "1.5" {
+		t.Error("want map a=1.5, get ", m, err)
+	}
+	if s, err := redis.Strings(values[1], nil); err != nil || len(s) != 1 || s[0] != "m" {
+		t.Error("want [m], get ", s, err)
+	}
+	if values[2] != int64(1) {
+		t.Error("want 1, get ", values[2])
+	}
+	if n, err := redis.Int64(values[3], nil); err != nil || n != 7 {
+		t.Error("want 7, get ", n, err)
+	}
+}
+
+func TestRESP3Node(t *testing.T) {
+	p := NewPool(Config{Protocol: ProtocolRESP3, ClientName: "goredis_resp3"})
+	defer p.Close()
+	p.SetNode(DefaultNodeName, "redis://:@localhost:6379")
+	node, _ := p.GetNode(DefaultNodeName)
+	helper := p.NewHelper()
+	helper.Del("resp3_hash", "resp3_set", "resp3_zset")
+
+	helper.HSet("resp3_hash", "name", "scofield")
+	if m, ok := mustReply(node.Command("HGETALL", "resp3_hash")).(map[string]interface{}); !ok || string(m["name"].([]byte)) != "scofield" {
+		t.Error("want map reply, get ", m)
+	}
+	if m, err := helper.HGetAllMap("resp3_hash"); err != nil || m["name"] != "scofield" {
+		t.Error("want name=scofield, get ", m, err)
+	}
+
+	helper.SAdd("resp3_set", "a", "b")
+	if s, ok := mustReply(node.Command("SMEMBERS", "resp3_set")).(map[string]struct{}); !ok || len(s) != 2 {
+		t.Error("want set reply, get ", s)
+	}
+	if members, err := helper.SMembers("resp3_set"); err != nil || len(members) != 2 {
+		t.Error("want 2 members, get ", members, err)
+	}
+
+	helper.ZAdd("resp3_zset", ZMember{Member: "m", Score: 1.5})
+	if score, ok := mustReply(node.Command("ZSCORE", "resp3_zset", "m")).(float64); !ok || score != 1.5 {
+		t.Error("want float64 1.5, get ", score)
+	}
+	if score, err := helper.ZScore("resp3_zset", "m"); err != nil || score != 1.5 {
+		t.Error("want 1.5, get ", score, err)
+	}
+	if members, err := helper.ZRangeWithScores("resp3_zset", 0, -1); err != nil || len(members) != 1 || members[0].Score != 1.5 {
+		t.Error("want m 1.5, get ", members, err)
+	}
+	if _, err := helper.GetString("resp3_missing"); err != ErrNil {
+		t.Error("want ErrNil, get ", err)
+	}
+
+	pipe := helper.Pipeline()
+	all := pipe.HGetAll("resp3_hash")
+	if _, err := pipe.Exec(); err != nil {
+		t.Error(err.Error())
+	}
+	if m, err := all.StringMap(); err != nil || m["name"] != "scofield" {
+		t.Error("want name=scofield, get ", m, err)
+	}
+	helper.Del("resp3_hash", "resp3_set", "resp3_zset")
+}
+
+func TestRESP3Subscriber(t *testing.T) {
+	p := NewPool(Config{Protocol: ProtocolRESP3})
+	defer p.Close()
+	p.SetNode(DefaultNodeName, "redis://:@localhost:6379")
+
+	s, err := p.NewHelper().Subscriber()
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	defer s.Close()
+	if err = s.Subscribe("resp3_channel"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	for ctx.Err() == nil {
+		if receivers, _ := p.NewHelper().Publish("resp3_channel", "hello"); receivers > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 50)
+	}
+	select {
+	case msg := <-s.Channel():
+		if string(msg.Data) != "hello" {
+			t.Error("want hello, get ", string(msg.Data))
+		}
+	case <-ctx.Done():
+		t.Error("wait message timeout")
+	}
+}
+
+// 忽略错误，只返回结果
+func mustReply(reply interface{}, _ error) interface{} {
+	return reply
+}
+
+func TestParseProtocol(t *testing.T) {
+	conf := parseNodeConfig("resp3", defaultConfig(), ini.Section{"protocol": "3", "client_name": " worker "})
+	if conf.Protocol != ProtocolRESP3 || conf.ClientName != "worker" {
+		t.Error("want protocol 3 and client name worker, get ", conf.Protocol, conf.ClientName)
+	}
+	if conf = parseNodeConfig("resp3", defaultConfig(), ini.Section{"protocol": "4"}); conf.Protocol != 0 {
+		t.Error("want invalid protocol ignored, get ", conf.Protocol)
+	}
+}
+
+// 启动一个RESP3的sentinel服务端，HELLO返回协议版本，SENTINEL replicas返回map数组
+func newFakeRESP3Sentinel(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		for {
+			args, err := readFakeCommand(br)
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(args[0]) {
+			case "HELLO":
+				conn.Write([]byte("%1\r\n+proto\r\n:3\r\n"))
+			case "SENTINEL":
+				conn.Write([]byte("*2\r\n" +
+					"%3\r\n+ip\r\n$8\r\n10.0.0.2\r\n+port\r\n$4\r\n6379\r\n+flags\r\n$5\r\nslave\r\n" +
+					"%3\r\n+ip\r\n$8\r\n10.0.0.3\r\n+port\r\n$4\r\n6379\r\n+flags\r\n$12\r\ns_down,slave\r\n"))
+			default:
+				conn.Write([]byte("-ERR unknown command\r\n"))
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+// 读取客户端发送的RESP数组格式的命令
+func readFakeCommand(br *bufio.Reader) (args []string, err error) {
+	var n int
+	if _, err = fmt.Fscanf(br, "*%d\r\n", &n); err != nil {
+		return
+	}
+	for i := 0; i < n; i++ {
+		var size int
+		if _, err = fmt.Fscanf(br, "$%d\r\n", &size); err != nil {
+			return
+		}
+		arg := make([]byte, size+2)
+		if _, err = io.ReadFull(br, arg); err != nil {
+			return
+		}
+		args = append(args, string(arg[:size]))
+	}
+	return
+}
+
+func TestRESP3Sentinel(t *testing.T) {
+	s := &sentinelClient{definition: nodeDefinition{
+		sentinels:  []string{newFakeRESP3Sentinel(t)},
+		masterName: "mymaster",
+		config:     Config{Protocol: ProtocolRESP3, Timeout: time.Second},
+	}}
+	addrs, err := s.discoverReplicas()
+	if err != nil || len(addrs) != 1 || addrs[0] != "10.0.0.2:6379" {
+		t.Error("want replica 10.0.0.2:6379, get ", addrs, err)
+	}
+}
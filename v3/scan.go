@@ -0,0 +1,222 @@
+package goredis
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+)
+
+// SCAN系列命令的选项
+type ScanOptions struct {
+	// 只返回匹配pattern的元素
+	Match string
+	// 每次迭代建议返回的元素数量
+	Count int64
+	// 只返回指定类型的key，只对SCAN有效，需要redis 6.0以上
+	Type string
+}
+
+// 在某个节点或分片上执行SCAN系列命令
+type scanTarget func(ctx context.Context, args ...interface{}) (interface{}, error)
+
+// SCAN系列命令的迭代器，使用方式：
+//
+//	it := helper.Scan(ScanOptions{Match: "user:*"})
+//	for it.Next(ctx) {
+//		key := it.Val()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+//
+// 迭代器不是并发安全的；和SCAN命令一样，迭代期间被修改的元素可能被返回多次或者不被返回
+type ScanIterator struct {
+	command string
+	key     string
+	options ScanOptions
+	// HSCAN/ZSCAN返回的是成对的元素
+	pair bool
+
+	targets []scanTarget
+	target  int
+	cursor  string
+	started bool
+
+	page  []string
+	pos   int
+	val   string
+	value string
+	err   error
+}
+
+// 迭代helper绑定节点上的所有key，集群节点会依次迭代每个master分片，用来替代KEYS
+func (h *Helper) Scan(options ScanOptions) *ScanIterator {
+	it := &ScanIterator{command: "SCAN", options: options}
+	node, err := h.pool.GetNode(h.nodeName)
+	if err != nil {
+		it.err = err
+		return it
+	}
+	it.targets, it.err = node.scanTargets()
+	return it
+}
+
+// 依次迭代p中的多个节点上的所有key，没有指定nodeNames时迭代所有不是其他节点slave的节点，
+// 避免同一个key在master和slave上各返回一次；集群节点会迭代每个master分片
+func (p *Pool) Scan(options ScanOptions, nodeNames ...string) *ScanIterator {
+	it := &ScanIterator{command: "SCAN", options: options}
+	if len(nodeNames) == 0 {
+		nodeNames = p.masterNodeNames()
+	}
+	for _, nodeName := range nodeNames {
+		node, err := p.GetNode(nodeName)
+		if err != nil {
+			it.err = err
+			return it
+		}
+		targets, err := node.scanTargets()
+		if err != nil {
+			it.err = err
+			return it
+		}
+		it.targets = append(it.targets, targets...)
+	}
+	return it
+}
+
+// 迭代hash的所有field，Val返回field，Value返回对应的值
+func (h *Helper) HScan(key string, options ScanOptions) *ScanIterator {
+	return h.keyScan("HSCAN", key, options, true)
+}
+
+// 迭代set的所有成员，Val返回成员
+func (h *Helper) SScan(key string, options ScanOptions) *ScanIterator {
+	return h.keyScan("SSCAN", key, options, false)
+}
+
+// 迭代有序集合的所有成员，Val返回成员，Value返回分数
+func (h *Helper) ZScan(key string, options ScanOptions) *ScanIterator {
+	return h.keyScan("ZSCAN", key, options, true)
+}
+
+// 新建迭代某个key的迭代器，命令会被路由到key所在的节点或分片
+func (h *Helper) keyScan(command, key string, options ScanOptions, pair bool) *ScanIterator {
+	it := &ScanIterator{command: command, key: key, options: options, pair: pair}
+	node, err := h.pool.GetNode(h.nodeName)
+	if err != nil {
+		it.err = err
+		return it
+	}
+	it.targets = []scanTarget{func(ctx context.Context, args ...interface{}) (interface{}, error) {
+		return node.CommandContext(ctx, command, args...)
+	}}
+	return it
+}
+
+// 获取执行SCAN的目标，集群节点返回每个master分片
+func (n *Node) scanTargets() ([]scanTarget, error) {
+	cluster := n.getCluster()
+	if cluster == nil {
+		return []scanTarget{func(ctx context.Context, args ...interface{}) (interface{}, error) {
+			return n.CommandContext(ctx, "SCAN", args...)
+		}}, nil
+	}
+
+	addrs, err := cluster.loadMasters()
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]scanTarget, 0, len(addrs))
+	for _, addr := range addrs {
+		pool := cluster.getPool(addr)
+		targets = append(targets, func(ctx context.Context, args ...interface{}) (interface{}, error) {
+			conn, err := getConnContext(ctx, pool)
+			if err != nil {
+				return nil, err
+			}
+			return doContext(ctx, conn, "SCAN", args...)
+		})
+	}
+	return targets, nil
+}
+
+// 迭代到下一个元素，没有更多元素或者出错时返回false，出错时通过Err获取错误
+func (it *ScanIterator) Next(ctx context.Context) bool {
+	step := 1
+	if it.pair {
+		step = 2
+	}
+	for {
+		if it.pos+step <= len(it.page) {
+			it.val = it.page[it.pos]
+			if it.pair {
+				it.value = it.page[it.pos+1]
+			}
+			it.pos += step
+			return true
+		}
+		if it.err != nil || it.target >= len(it.targets) {
+			return false
+		}
+		if it.started && it.cursor == "0" {
+			// 当前节点迭代完毕，继续迭代下一个节点
+			it.target++
+			it.started = false
+			continue
+		}
+		if !it.started {
+			it.cursor = "0"
+		}
+
+		it.err = it.fetch(ctx)
+		it.started = true
+	}
+}
+
+// 获取下一页元素
+func (it *ScanIterator) fetch(ctx context.Context) error {
+	args := redis.Args{}
+	if it.key != "" {
+		args = args.Add(it.key)
+	}
+	args = args.Add(it.cursor)
+	if it.options.Match != "" {
+		args = args.Add("MATCH", it.options.Match)
+	}
+	if it.options.Count > 0 {
+		args = args.Add("COUNT", it.options.Count)
+	}
+	if it.options.Type != "" && it.key == "" {
+		args = args.Add("TYPE", it.options.Type)
+	}
+
+	values, err := redis.Values(resp2Reply(it.targets[it.target](ctx, args...)))
+	if err != nil {
+		return err
+	}
+	if len(values) != 2 {
+		return fmt.Errorf("redis: unexpected %s reply %v", it.command, values)
+	}
+	if it.cursor, err = redis.String(values[0], nil); err != nil {
+		return err
+	}
+	if it.page, err = redis.Strings(values[1], nil); err != nil {
+		return err
+	}
+	it.pos = 0
+	return nil
+}
+
+// 获取当前元素：SCAN为key，HSCAN为field，SSCAN和ZSCAN为成员
+func (it *ScanIterator) Val() string {
+	return it.val
+}
+
+// 获取当前元素对应的值：HSCAN为field的值，ZSCAN为成员的分数，其他命令为空
+func (it *ScanIterator) Value() string {
+	return it.value
+}
+
+// 获取迭代过程中的错误
+func (it *ScanIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,102 @@
+package goredis
+
+import (
+	"context"
+	"github.com/vaughan0/go-ini"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	keys := make([]string, 0, 30)
+	for i := 0; i < 30; i++ {
+		key := "scan_test_" + strconv.Itoa(i)
+		keys = append(keys, key)
+		helper.Set(key, i)
+	}
+	defer helper.Del(keys...)
+
+	it := helper.Scan(ScanOptions{Match: "scan_test_*", Count: 5, Type: "string"})
+	found := make([]string, 0, 30)
+	for it.Next(context.Background()) {
+		found = append(found, it.Val())
+	}
+	if err := it.Err(); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	sort.Strings(found)
+	sort.Strings(keys)
+	if len(found) != len(keys) {
+		t.Error("want ", len(keys), " keys, get ", len(found))
+		return
+	}
+	for i := range keys {
+		if found[i] != keys[i] {
+			t.Error("want ", keys[i], ", get ", found[i])
+		}
+	}
+
+	// 依次迭代Pool中所有的master节点，scan_b是scan_a的slave，每个key只返回一次
+	p := NewPool(Config{})
+	defer p.Close()
+	p.LoadNodes(ini.File{
+		"redis_node_scan_a": ini.Section{
+			"scheme": "redis://:@localhost:6379",
+			"slaves": "scan_b",
+		},
+		"redis_node_scan_b": ini.Section{
+			"scheme": "redis://:@localhost:6379",
+		},
+	})
+	count := 0
+	for it = p.Scan(ScanOptions{Match: "scan_test_1*"}); it.Next(context.Background()); {
+		count++
+	}
+	if it.Err() != nil || count != 11 {
+		t.Error("want 11 keys, get ", count, it.Err())
+	}
+
+	if it = NewHelper("not_exist").Scan(ScanOptions{}); it.Next(context.Background()) || it.Err() != ErrNodeNotFound {
+		t.Error("want ErrNodeNotFound, get ", it.Err())
+	}
+}
+
+func TestKeyScan(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("hscan_test", "sscan_test", "zscan_test")
+	defer helper.Del("hscan_test", "sscan_test", "zscan_test")
+
+	helper.HMset("hscan_test", map[string]interface{}{"a": "1", "b": "2"})
+	helper.SAdd("sscan_test", "x", "y", "z")
+	helper.ZAdd("zscan_test", ZMember{"m", 1.5})
+
+	values := make(map[string]string)
+	it := helper.HScan("hscan_test", ScanOptions{})
+	for it.Next(context.Background()) {
+		values[it.Val()] = it.Value()
+	}
+	if it.Err() != nil || len(values) != 2 || values["a"] != "1" || values["b"] != "2" {
+		t.Error("unexpected HSCAN result ", values, it.Err())
+	}
+
+	members := 0
+	for it = helper.SScan("sscan_test", ScanOptions{Match: "[xy]"}); it.Next(context.Background()); {
+		members++
+	}
+	if it.Err() != nil || members != 2 {
+		t.Error("want 2 members, get ", members, it.Err())
+	}
+
+	it = helper.ZScan("zscan_test", ScanOptions{})
+	if !it.Next(context.Background()) || it.Val() != "m" || it.Value() != "1.5" {
+		t.Error("unexpected ZSCAN result ", it.Val(), it.Value(), it.Err())
+	}
+	if it.Next(context.Background()) {
+		t.Error("want ZSCAN finished")
+	}
+}
@@ -0,0 +1,205 @@
+package goredis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"strings"
+)
+
+// lua脚本，优先通过EVALSHA执行，服务端没有缓存该脚本(NOSCRIPT)时使用EVAL执行，EVAL执行后脚本会被服务端缓存
+// 脚本可以通过Pool.RegisterScript注册，注册后会被预加载到Pool中所有节点的master和replica上
+// 集群节点上脚本的所有key需要在同一个slot中，命令会被路由到第一个key所在的分片
+type Script struct {
+	keyCount int
+	src      string
+	hash     string
+}
+
+// 新建脚本，keyCount为脚本中key的数量，小于0时执行脚本的第一个参数为key的数量(与redigo的redis.NewScript一致)
+func NewScript(keyCount int, src string) *Script {
+	h := sha1.Sum([]byte(src))
+	return &Script{keyCount: keyCount, src: src, hash: hex.EncodeToString(h[:])}
+}
+
+// 获取脚本的SHA1，即EVALSHA使用的值
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// 获取脚本的源码
+func (s *Script) Src() string {
+	return s.src
+}
+
+// 生成EVAL/EVALSHA的参数，spec为脚本源码或者SHA1
+func (s *Script) args(spec string, keysAndArgs []interface{}) []interface{} {
+	args := make([]interface{}, 0, len(keysAndArgs)+2)
+	args = append(args, spec)
+	if s.keyCount >= 0 {
+		args = append(args, s.keyCount)
+	}
+	return append(args, keysAndArgs...)
+}
+
+// 判断错误是否为服务端没有缓存脚本
+func isNoScript(err error) bool {
+	redisErr, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(redisErr), "NOSCRIPT")
+}
+
+// 在helper绑定的节点上执行脚本，keysAndArgs依次为脚本的key和参数
+func (h *Helper) RunScript(script *Script, keysAndArgs ...interface{}) (reply interface{}, err error) {
+	reply, err = h.command("EVALSHA", script.args(script.hash, keysAndArgs)...)
+	if isNoScript(err) {
+		reply, err = h.command("EVAL", script.args(script.src, keysAndArgs)...)
+	}
+	return
+}
+
+// 在节点上执行脚本，见Helper.RunScript
+func (n *Node) RunScript(ctx context.Context, script *Script, keysAndArgs ...interface{}) (reply interface{}, err error) {
+	reply, err = n.CommandContext(ctx, "EVALSHA", script.args(script.hash, keysAndArgs)...)
+	if isNoScript(err) {
+		reply, err = n.CommandContext(ctx, "EVAL", script.args(script.src, keysAndArgs)...)
+	}
+	return
+}
+
+// 将执行脚本的命令加入队列，使用EVALSHA执行
+// pipeline中返回NOSCRIPT的命令会使用EVAL单独重试；事务会在MULTI之前通过SCRIPT LOAD加载脚本
+func (q *cmdQueue) RunScript(script *Script, keysAndArgs ...interface{}) *PipelineCmd {
+	cmd := q.Do("EVALSHA", script.args(script.hash, keysAndArgs)...)
+	cmd.script = script
+	return cmd
+}
+
+// 使用EVAL重新执行返回NOSCRIPT的脚本命令
+func (c *PipelineCmd) evalArgs() []interface{} {
+	args := append([]interface{}(nil), c.args...)
+	args[0] = c.script.src
+	return args
+}
+
+// 注册脚本到默认Pool，见Pool.RegisterScript
+func RegisterScript(scripts ...*Script) {
+	pool.RegisterScript(scripts...)
+}
+
+// 注册脚本，脚本会在后台预加载到Pool中已有的节点上，之后新增或者替换的节点也会在创建时预加载
+// 预加载失败只会输出警告，执行时服务端没有缓存脚本仍然会回退到EVAL
+func (p *Pool) RegisterScript(scripts ...*Script) {
+	p.mu.Lock()
+	p.scripts = append(p.scripts, scripts...)
+	nodes := make(map[string]*Node, len(p.nodes))
+	for name, node := range p.nodes {
+		nodes[name] = node
+	}
+	p.mu.Unlock()
+
+	for name, node := range nodes {
+		go node.preloadScripts(name, scripts)
+	}
+}
+
+// 获取已经注册的脚本
+func (p *Pool) Scripts() []*Script {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return append([]*Script(nil), p.scripts...)
+}
+
+// 在后台预加载脚本，失败时输出警告
+func (n *Node) preloadScripts(nodeName string, scripts []*Script) {
+	if len(scripts) == 0 {
+		return
+	}
+	if err := n.LoadScripts(context.Background(), scripts...); err != nil {
+		fmt.Printf("[warning][redis] preload scripts to node %s fail,error:%s\n", nodeName, err.Error())
+	}
+}
+
+// 通过SCRIPT LOAD将脚本加载到节点上：集群节点加载到每个master分片，sentinel节点加载到master和所有replica，
+// 单机节点加载到节点本身；节点配置的slave是Pool中独立的节点，需要单独加载
+// 返回最后一个出错的错误，出错时其他目标仍然会继续加载
+func (n *Node) LoadScripts(ctx context.Context, scripts ...*Script) (err error) {
+	var pools []*redis.Pool
+	if pools, err = n.scriptPools(); err != nil {
+		return
+	}
+	for _, redisPool := range pools {
+		if loadErr := loadScripts(ctx, redisPool, scripts); loadErr != nil {
+			err = loadErr
+		}
+	}
+	return
+}
+
+// 获取需要加载脚本的连接池，脚本不会被复制到replica上，因此sentinel节点还需要加载到每个replica
+func (n *Node) scriptPools() ([]*redis.Pool, error) {
+	pools, err := n.masterPools()
+	if err != nil {
+		return nil, err
+	}
+	if sentinel := n.getSentinel(); sentinel != nil {
+		for _, replica := range sentinel.replicaNodes() {
+			pools = append(pools, replica.GetPool())
+		}
+	}
+	return pools, nil
+}
+
+// 获取节点所有master的连接池：集群节点返回每个master分片，其他节点返回节点(sentinel节点为当前master)本身
+func (n *Node) masterPools() ([]*redis.Pool, error) {
+	cluster := n.getCluster()
+	if cluster == nil {
+		return []*redis.Pool{n.GetPool()}, nil
+	}
+	addrs, err := cluster.loadMasters()
+	if err != nil {
+		return nil, err
+	}
+	pools := make([]*redis.Pool, 0, len(addrs))
+	for _, addr := range addrs {
+		pools = append(pools, cluster.getPool(addr))
+	}
+	return pools, nil
+}
+
+// 依次在节点的每个master上执行fn，返回最后一个出错的错误，出错时其他master仍然会继续执行
+func (n *Node) eachMaster(ctx context.Context, fn func(conn redis.Conn) error) (err error) {
+	var pools []*redis.Pool
+	if pools, err = n.masterPools(); err != nil {
+		return
+	}
+	for _, redisPool := range pools {
+		conn, connErr := getConnContext(ctx, redisPool)
+		if connErr != nil {
+			err = connErr
+			continue
+		}
+		if fnErr := fn(conn); fnErr != nil {
+			err = fnErr
+		}
+		conn.Close()
+	}
+	return
+}
+
+// 使用一个连接加载所有脚本
+func loadScripts(ctx context.Context, redisPool *redis.Pool, scripts []*Script) error {
+	conn, err := getConnContext(ctx, redisPool)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, script := range scripts {
+		if _, err = connDo(ctx, conn, "SCRIPT", "LOAD", script.src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
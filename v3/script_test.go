@@ -0,0 +1,119 @@
+package goredis
+
+import (
+	"context"
+	"github.com/gomodule/redigo/redis"
+	"testing"
+	"time"
+)
+
+var testIncrScript = NewScript(1, `return redis.call("incrby", KEYS[1], ARGV[1])`)
+
+// 判断脚本是否已经被服务端缓存
+func scriptExists(node *Node, script *Script) bool {
+	exists, err := redis.Ints(node.Command("SCRIPT", "EXISTS", script.Hash()))
+	return err == nil && len(exists) == 1 && exists[0] == 1
+}
+
+func TestScript(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("script_counter")
+	node, _ := GetNode()
+
+	if _, err := node.Command("SCRIPT", "FLUSH"); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	// 服务端没有缓存时回退到EVAL
+	if n, err := redis.Int64(helper.RunScript(testIncrScript, "script_counter", 2)); err != nil || n != 2 {
+		t.Error("want 2, get ", n, err)
+	}
+	if !scriptExists(node, testIncrScript) {
+		t.Error("want script cached after EVAL")
+	}
+	if n, err := redis.Int64(node.RunScript(context.Background(), testIncrScript, "script_counter", 3)); err != nil || n != 5 {
+		t.Error("want 5, get ", n, err)
+	}
+
+	// keyCount小于0时第一个参数为key的数量
+	dynamic := NewScript(-1, `return #KEYS`)
+	if n, err := redis.Int64(helper.RunScript(dynamic, 2, "a", "b")); err != nil || n != 2 {
+		t.Error("want 2, get ", n, err)
+	}
+	helper.Del("script_counter")
+}
+
+func TestScriptPipeline(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("script_counter")
+	node, _ := GetNode()
+	node.Command("SCRIPT", "FLUSH")
+
+	p := helper.Pipeline()
+	first := p.RunScript(testIncrScript, "script_counter", 1)
+	second := p.RunScript(testIncrScript, "script_counter", 1)
+	if _, err := p.Exec(); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if n, err := first.Int64(); err != nil || n < 1 {
+		t.Error("want counter, get ", n, err)
+	}
+	if n, err := second.Int64(); err != nil || n < 1 {
+		t.Error("want counter, get ", n, err)
+	}
+	if n, _ := helper.GetInt64("script_counter"); n != 2 {
+		t.Error("want 2, get ", n)
+	}
+
+	node.Command("SCRIPT", "FLUSH")
+	var incr *PipelineCmd
+	err := helper.Tx(context.Background(), []string{"script_counter"}, func(tx *Tx) error {
+		incr = tx.RunScript(testIncrScript, "script_counter", 3)
+		return nil
+	})
+	if err != nil {
+		t.Error(err.Error())
+	}
+	if n, err := incr.Int64(); err != nil || n != 5 {
+		t.Error("want 5, get ", n, err)
+	}
+	helper.Del("script_counter")
+}
+
+func TestRegisterScript(t *testing.T) {
+	p := NewPool(Config{})
+	defer p.Close()
+	p.SetNode("script", "redis://127.0.0.1:6379")
+	node, _ := p.GetNode("script")
+	node.Command("SCRIPT", "FLUSH")
+
+	script := NewScript(0, `return "registered"`)
+	p.RegisterScript(script)
+	if len(p.Scripts()) != 1 {
+		t.Error("want 1 registered script, get ", len(p.Scripts()))
+	}
+	if !waitScript(node, script) {
+		t.Error("want script preloaded to existing node")
+	}
+
+	// 新增的节点在创建时预加载
+	node.Command("SCRIPT", "FLUSH")
+	p.ReplaceNode("script", "redis://127.0.0.1:6379")
+	if !waitScript(node, script) {
+		t.Error("want script preloaded to replaced node")
+	}
+}
+
+// 等待脚本被后台预加载
+func waitScript(node *Node, script *Script) bool {
+	for i := 0; i < 50; i++ {
+		if scriptExists(node, script) {
+			return true
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	return false
+}
@@ -0,0 +1,326 @@
+package goredis
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// 所有sentinel都无法提供master地址
+	ErrMasterNotFound = errors.New("master not found by sentinels")
+
+	// 没有收到sentinel事件时主动刷新master和replica的间隔
+	SentinelRefreshInterval = time.Second * 30
+)
+
+const (
+	// 连接sentinel失败后重连的等待时间
+	sentinelRetryInterval = time.Second
+)
+
+// sentinel客户端，负责通过sentinel发现master和replica，并在故障转移后切换连接池
+type sentinelClient struct {
+	definition nodeDefinition
+
+	mu       sync.RWMutex
+	addr     string
+	pool     *redis.Pool
+	replicas map[string]*Node
+	closed   bool
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// 新建sentinel客户端，并在后台监听sentinel的故障转移事件
+func newSentinelClient(definition nodeDefinition) *sentinelClient {
+	s := &sentinelClient{
+		definition: definition,
+		replicas:   make(map[string]*Node),
+		stop:       make(chan struct{}),
+	}
+	s.pool = s.newMasterPool()
+	go s.watch()
+	return s
+}
+
+// 新建master的连接池，连接时使用当前已知的master地址，未知时先通过sentinel查询
+func (s *sentinelClient) newMasterPool() *redis.Pool {
+	p := newAddrPool("", s.definition.password, s.definition.db, s.definition.config)
+	p.Dial = func() (redis.Conn, error) {
+		addr, err := s.masterAddr()
+		if err != nil {
+			return nil, err
+		}
+		return dialAddr(addr, s.definition.password, s.definition.db, s.definition.config)
+	}
+	return p
+}
+
+// 获取master的连接池
+func (s *sentinelClient) masterPool() *redis.Pool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pool
+}
+
+// 获取当前master的地址，未知时通过sentinel查询
+func (s *sentinelClient) masterAddr() (addr string, err error) {
+	s.mu.RLock()
+	addr = s.addr
+	s.mu.RUnlock()
+	if addr != "" {
+		return
+	}
+
+	if addr, err = s.discoverMaster(); err != nil {
+		return
+	}
+	s.setMaster(addr)
+	return
+}
+
+// 设置master地址，地址发生变化时切换到新的连接池，旧的连接池在连接归还后关闭
+func (s *sentinelClient) setMaster(addr string) {
+	s.mu.Lock()
+	if s.closed || s.addr == addr {
+		s.mu.Unlock()
+		return
+	}
+	oldAddr, oldPool := s.addr, s.pool
+	s.addr = addr
+	if oldAddr != "" {
+		// 之前的连接都指向旧的master，需要整体替换连接池
+		s.pool = s.newMasterPool()
+	} else {
+		oldPool = nil
+	}
+	s.mu.Unlock()
+
+	drainPool(oldPool)
+}
+
+// 依次询问sentinel获取master地址
+func (s *sentinelClient) discoverMaster() (addr string, err error) {
+	for _, sentinelAddr := range s.definition.sentinels {
+		var reply []string
+		reply, err = redis.Strings(s.sentinelCommand(sentinelAddr, "get-master-addr-by-name", s.definition.masterName))
+		if err == nil && len(reply) == 2 {
+			return net.JoinHostPort(reply[0], reply[1]), nil
+		}
+	}
+	if err == nil || err == redis.ErrNil {
+		err = ErrMasterNotFound
+	}
+	return
+}
+
+// 依次询问sentinel获取可用的replica地址
+func (s *sentinelClient) discoverReplicas() (addrs []string, err error) {
+	for _, sentinelAddr := range s.definition.sentinels {
+		var replies []interface{}
+		replies, err = redis.Values(s.sentinelCommand(sentinelAddr, "replicas", s.definition.masterName))
+		if err != nil {
+			// redis 5之前的sentinel只支持SENTINEL slaves
+			replies, err = redis.Values(s.sentinelCommand(sentinelAddr, "slaves", s.definition.masterName))
+		}
+		if err == nil {
+			return parseSentinelReplicas(replies)
+		}
+	}
+	return
+}
+
+// 解析SENTINEL replicas的返回，过滤掉下线和断开连接的replica
+func parseSentinelReplicas(replies []interface{}) (addrs []string, err error) {
+	addrs = make([]string, 0, len(replies))
+	for _, reply := range replies {
+		var info map[string]string
+		if info, err = redis.StringMap(reply, nil); err != nil {
+			return
+		}
+		flags := "," + info["flags"] + ","
+		if strings.Contains(flags, ",s_down,") || strings.Contains(flags, ",o_down,") ||
+			strings.Contains(flags, ",disconnected,") {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(info["ip"], info["port"]))
+	}
+	return
+}
+
+// 在某个sentinel上执行SENTINEL子命令，RESP3连接的结果转换为RESP2格式
+func (s *sentinelClient) sentinelCommand(sentinelAddr string, args ...interface{}) (interface{}, error) {
+	conn, err := dialAddr(sentinelAddr, s.definition.sentinelPassword, 0, s.definition.config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return resp2Reply(conn.Do("SENTINEL", args...))
+}
+
+// 刷新replica列表，新增的replica创建连接池，消失的replica的连接池在连接归还后关闭
+func (s *sentinelClient) refreshReplicas() error {
+	addrs, err := s.discoverReplicas()
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]bool)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	for _, addr := range addrs {
+		current[addr] = true
+		if _, exist := s.replicas[addr]; !exist {
+			s.replicas[addr] = newAddrNode(addr, s.definition.password, s.definition.db, s.definition.config)
+		}
+	}
+	for addr, replica := range s.replicas {
+		if !current[addr] {
+			delete(s.replicas, addr)
+			replica.resources().drain()
+		}
+	}
+	return nil
+}
+
+// 新建直接连接某个地址的节点
+func newAddrNode(addr, password string, db int, nodeConf Config) *Node {
+	return &Node{
+		pool:       newAddrPool(addr, password, db, nodeConf),
+		definition: nodeDefinition{mode: NodeModeStandalone, config: nodeConf},
+		slaves:     make([]string, 0),
+		config:     nodeConf,
+	}
+}
+
+// 获取当前可用的replica节点
+func (s *sentinelClient) replicaNodes() []*Node {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	nodes := make([]*Node, 0, len(s.replicas))
+	for _, replica := range s.replicas {
+		nodes = append(nodes, replica)
+	}
+	return nodes
+}
+
+// 刷新master地址和replica列表
+func (s *sentinelClient) refresh() {
+	if addr, err := s.discoverMaster(); err == nil {
+		s.setMaster(addr)
+	}
+	s.refreshReplicas()
+}
+
+// 订阅sentinel的事件，+switch-master时切换master，replica状态变化时刷新replica列表
+// 连接断开后依次重连下一个sentinel，重连后主动刷新一次，避免遗漏断开期间的事件
+func (s *sentinelClient) watch() {
+	for i := 0; ; {
+		s.refresh()
+
+		sentinelAddr := s.definition.sentinels[i%len(s.definition.sentinels)]
+		err := s.subscribe(sentinelAddr)
+
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		if err != nil {
+			fmt.Printf("[warning][redis] sentinel %s subscribe fail: %s\n", sentinelAddr, err.Error())
+			i++
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(sentinelRetryInterval):
+			}
+		}
+	}
+}
+
+// 在某个sentinel上订阅事件直到连接出错或者客户端关闭
+func (s *sentinelClient) subscribe(sentinelAddr string) error {
+	conn, err := dialAddr(sentinelAddr, s.definition.sentinelPassword, 0, s.definition.config)
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+
+	if err = psc.Subscribe("+switch-master", "+slave", "+sdown", "-sdown"); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.stop:
+			// 关闭连接以中断阻塞中的Receive
+			psc.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		switch v := psc.ReceiveWithTimeout(SentinelRefreshInterval).(type) {
+		case redis.Message:
+			s.handleEvent(v.Channel, string(v.Data))
+		case error:
+			if netErr, ok := v.(net.Error); ok && netErr.Timeout() {
+				// 长时间没有事件，主动刷新一次后重新订阅
+				return nil
+			}
+			select {
+			case <-s.stop:
+				return nil
+			default:
+			}
+			return v
+		}
+	}
+}
+
+// 处理sentinel事件
+func (s *sentinelClient) handleEvent(channel, data string) {
+	switch channel {
+	case "+switch-master":
+		// 格式：<master name> <old ip> <old port> <new ip> <new port>
+		fields := strings.Fields(data)
+		if len(fields) == 5 && fields[0] == s.definition.masterName {
+			s.setMaster(net.JoinHostPort(fields[3], fields[4]))
+			s.refreshReplicas()
+		}
+	default:
+		// 格式：<instance type> <name> <ip> <port> @ <master name> <master ip> <master port>
+		if strings.Contains(data, "@ "+s.definition.masterName+" ") {
+			s.refreshReplicas()
+		}
+	}
+}
+
+// 停止监听sentinel事件，并使用release释放master和所有replica的连接池
+func (s *sentinelClient) close(release func(*redis.Pool)) {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	release(s.pool)
+	for addr, replica := range s.replicas {
+		delete(s.replicas, addr)
+		release(replica.GetPool())
+	}
+}
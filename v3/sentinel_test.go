@@ -0,0 +1,85 @@
+package goredis
+
+import (
+	"github.com/vaughan0/go-ini"
+	"testing"
+)
+
+func TestSentinelDefinition(t *testing.T) {
+	definitions := parseNodeDefinitions(ini.File{
+		"redis_node_default": ini.Section{
+			"sentinels":   "127.0.0.1:26379, 127.0.0.1:26380",
+			"master_name": "mymaster",
+			"db":          "2",
+		},
+		"redis_node_invalid": ini.Section{
+			"sentinels": "127.0.0.1:26379",
+		},
+	}, defaultConfig())
+
+	definition, exist := definitions[DefaultNodeName]
+	if !exist {
+		t.Error("sentinel node not parsed")
+		return
+	}
+	if definition.mode != NodeModeSentinel || len(definition.sentinels) != 2 ||
+		definition.masterName != "mymaster" || definition.db != 2 {
+		t.Errorf("sentinel definition wrong,get %#v", definition)
+	}
+	if _, exist = definitions["invalid"]; exist {
+		t.Error("sentinel node without master_name should be ignored")
+	}
+}
+
+func TestParseSentinelReplicas(t *testing.T) {
+	replicas := []interface{}{
+		[]interface{}{[]byte("ip"), []byte("10.0.0.2"), []byte("port"), []byte("6379"), []byte("flags"), []byte("slave")},
+		[]interface{}{[]byte("ip"), []byte("10.0.0.3"), []byte("port"), []byte("6379"), []byte("flags"), []byte("s_down,slave")},
+		[]interface{}{[]byte("ip"), []byte("10.0.0.4"), []byte("port"), []byte("6379"), []byte("flags"), []byte("slave,disconnected")},
+	}
+	addrs, err := parseSentinelReplicas(replicas)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(addrs) != 1 || addrs[0] != "10.0.0.2:6379" {
+		t.Error("want only healthy replica 10.0.0.2:6379,get ", addrs)
+	}
+}
+
+func TestAddrNodeGetSlave(t *testing.T) {
+	node := newAddrNode("127.0.0.1:6379", "", 0, defaultConfig())
+	defer node.resources().close()
+	if _, err := node.GetSlave("slave1"); err != ErrNodeNotFound {
+		t.Error("want ErrNodeNotFound, get ", err)
+	}
+}
+
+func TestSentinelSwitchMaster(t *testing.T) {
+	s := &sentinelClient{
+		definition: nodeDefinition{
+			mode:       NodeModeSentinel,
+			sentinels:  []string{"127.0.0.1:1"},
+			masterName: "mymaster",
+			config:     defaultConfig(),
+		},
+		replicas: make(map[string]*Node),
+		stop:     make(chan struct{}),
+	}
+	s.pool = s.newMasterPool()
+	defer s.close(drainPool)
+
+	s.setMaster("10.0.0.1:6379")
+	oldPool := s.masterPool()
+	s.handleEvent("+switch-master", "othermaster 10.0.0.1 6379 10.0.0.3 6379")
+	if addr, _ := s.masterAddr(); addr != "10.0.0.1:6379" {
+		t.Error("switch event of other master should be ignored,get ", addr)
+	}
+	s.handleEvent("+switch-master", "mymaster 10.0.0.1 6379 10.0.0.2 6380")
+	if addr, _ := s.masterAddr(); addr != "10.0.0.2:6380" {
+		t.Error("want master 10.0.0.2:6380,get ", addr)
+	}
+	if s.masterPool() == oldPool {
+		t.Error("master pool not switched")
+	}
+}
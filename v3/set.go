@@ -0,0 +1,83 @@
+package goredis
+
+import (
+	"github.com/gomodule/redigo/redis"
+)
+
+// sadd command，返回新加入的成员数量
+func (h *Helper) SAdd(key string, members ...interface{}) (added int64, err error) {
+	added, err = redis.Int64(h.command("SADD", redis.Args{}.Add(key).Add(members...)...))
+	return
+}
+
+// srem command，返回移除的成员数量
+func (h *Helper) SRem(key string, members ...interface{}) (removed int64, err error) {
+	removed, err = redis.Int64(h.command("SREM", redis.Args{}.Add(key).Add(members...)...))
+	return
+}
+
+// smembers command
+func (h *Helper) SMembers(key string) (members []string, err error) {
+	members, err = redis.Strings(h.command("SMEMBERS", key))
+	return
+}
+
+// sismember command
+func (h *Helper) SIsMember(key string, member interface{}) (exist bool, err error) {
+	exist, err = redis.Bool(h.command("SISMEMBER", key, member))
+	return
+}
+
+// scard command
+func (h *Helper) SCard(key string) (count int64, err error) {
+	count, err = redis.Int64(h.command("SCARD", key))
+	return
+}
+
+// sinter command
+func (h *Helper) SInter(keys ...string) (members []string, err error) {
+	members, err = redis.Strings(h.command("SINTER", redis.Args{}.AddFlat(keys)...))
+	return
+}
+
+// sinterstore command，返回destination中的成员数量
+func (h *Helper) SInterStore(destination string, keys ...string) (count int64, err error) {
+	count, err = redis.Int64(h.command("SINTERSTORE", redis.Args{}.Add(destination).AddFlat(keys)...))
+	return
+}
+
+// sunion command
+func (h *Helper) SUnion(keys ...string) (members []string, err error) {
+	members, err = redis.Strings(h.command("SUNION", redis.Args{}.AddFlat(keys)...))
+	return
+}
+
+// sunionstore command，返回destination中的成员数量
+func (h *Helper) SUnionStore(destination string, keys ...string) (count int64, err error) {
+	count, err = redis.Int64(h.command("SUNIONSTORE", redis.Args{}.Add(destination).AddFlat(keys)...))
+	return
+}
+
+// sdiff command
+func (h *Helper) SDiff(keys ...string) (members []string, err error) {
+	members, err = redis.Strings(h.command("SDIFF", redis.Args{}.AddFlat(keys)...))
+	return
+}
+
+// sdiffstore command，返回destination中的成员数量
+func (h *Helper) SDiffStore(destination string, keys ...string) (count int64, err error) {
+	count, err = redis.Int64(h.command("SDIFFSTORE", redis.Args{}.Add(destination).AddFlat(keys)...))
+	return
+}
+
+// srandmember command，count大于0时返回不重复的成员，小于0时可能返回重复的成员
+func (h *Helper) SRandMember(key string, count int64) (members []string, err error) {
+	members, err = redis.Strings(h.command("SRANDMEMBER", key, count))
+	return
+}
+
+// spop command，随机移除并返回最多count个成员
+func (h *Helper) SPop(key string, count int64) (members []string, err error) {
+	members, err = redis.Strings(h.command("SPOP", key, count))
+	return
+}
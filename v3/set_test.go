@@ -0,0 +1,56 @@
+package goredis
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestSetCommands(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("set_a", "set_b", "set_dest")
+	defer helper.Del("set_a", "set_b", "set_dest")
+
+	if added, err := helper.SAdd("set_a", "go", "redis", "mysql"); err != nil || added != 3 {
+		t.Error("want 3 added, get ", added, err)
+		return
+	}
+	helper.SAdd("set_b", "redis", "kafka")
+
+	if exist, err := helper.SIsMember("set_a", "go"); err != nil || !exist {
+		t.Error("want go in set_a, get ", exist, err)
+	}
+	if removed, err := helper.SRem("set_a", "mysql", "not_exist"); err != nil || removed != 1 {
+		t.Error("want 1 removed, get ", removed, err)
+	}
+	if count, err := helper.SCard("set_a"); err != nil || count != 2 {
+		t.Error("want 2 members, get ", count, err)
+	}
+
+	members, err := helper.SMembers("set_a")
+	sort.Strings(members)
+	if err != nil || len(members) != 2 || members[0] != "go" || members[1] != "redis" {
+		t.Error("want [go redis], get ", members, err)
+	}
+	if members, err = helper.SInter("set_a", "set_b"); err != nil || len(members) != 1 || members[0] != "redis" {
+		t.Error("want [redis], get ", members, err)
+	}
+	if members, err = helper.SDiff("set_a", "set_b"); err != nil || len(members) != 1 || members[0] != "go" {
+		t.Error("want [go], get ", members, err)
+	}
+	if members, err = helper.SUnion("set_a", "set_b"); err != nil || len(members) != 3 {
+		t.Error("want 3 members, get ", members, err)
+	}
+	if count, err := helper.SUnionStore("set_dest", "set_a", "set_b"); err != nil || count != 3 {
+		t.Error("want 3 members stored, get ", count, err)
+	}
+	if count, err := helper.SInterStore("set_dest", "set_a", "set_b"); err != nil || count != 1 {
+		t.Error("want 1 member stored, get ", count, err)
+	}
+	if count, err := helper.SDiffStore("set_dest", "set_b", "set_a"); err != nil || count != 1 {
+		t.Error("want 1 member stored, get ", count, err)
+	}
+	if members, err = helper.SRandMember("set_a", 5); err != nil || len(members) != 2 {
+		t.Error("want 2 random members, get ", members, err)
+	}
+}
@@ -0,0 +1,436 @@
+package goredis
+
+import (
+	"context"
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"strings"
+	"time"
+)
+
+const (
+	// XRead和XReadGroup的block参数，不阻塞
+	XNoBlock time.Duration = 0
+	// XRead和XReadGroup的block参数，一直阻塞直到有消息或者helper的ctx结束
+	XBlockForever time.Duration = -1
+)
+
+// stream中的一条消息
+type XMessage struct {
+	ID     string
+	Values map[string]string
+}
+
+// XREAD/XREADGROUP返回的某个stream的消息
+type XStream struct {
+	Stream   string
+	Messages []XMessage
+}
+
+// 消费组的待确认消息概况
+type XPendingSummary struct {
+	// 待确认的消息数量
+	Count int64
+	// 最小和最大的待确认消息ID
+	Lower string
+	Upper string
+	// 每个消费者待确认的消息数量
+	Consumers map[string]int64
+}
+
+// 一条待确认消息的详情
+type XPendingEntry struct {
+	ID       string
+	Consumer string
+	// 距离上次投递的时间
+	Idle time.Duration
+	// 投递的次数
+	RetryCount int64
+}
+
+// xadd command，id一般为"*"，由redis生成，values可以是map或struct，返回消息ID
+func (h *Helper) XAdd(stream, id string, values interface{}) (newID string, err error) {
+	newID, err = redis.String(h.command("XADD", redis.Args{}.Add(stream, id).AddFlat(values)...))
+	return
+}
+
+// xlen command
+func (h *Helper) XLen(stream string) (length int64, err error) {
+	length, err = redis.Int64(h.command("XLEN", stream))
+	return
+}
+
+// xdel command
+func (h *Helper) XDel(stream string, ids ...string) (deleted int64, err error) {
+	deleted, err = redis.Int64(h.command("XDEL", redis.Args{}.Add(stream).AddFlat(ids)...))
+	return
+}
+
+// xrange command，start和end可以使用"-"和"+"，count大于0时限制返回数量
+func (h *Helper) XRange(stream, start, end string, count ...int64) (messages []XMessage, err error) {
+	args := redis.Args{}.Add(stream, start, end)
+	if len(count) > 0 && count[0] > 0 {
+		args = args.Add("COUNT", count[0])
+	}
+	messages, err = xMessages(h.command("XRANGE", args...))
+	return
+}
+
+// xrevrange command，start和end可以使用"+"和"-"，count大于0时限制返回数量
+func (h *Helper) XRevRange(stream, end, start string, count ...int64) (messages []XMessage, err error) {
+	args := redis.Args{}.Add(stream, end, start)
+	if len(count) > 0 && count[0] > 0 {
+		args = args.Add("COUNT", count[0])
+	}
+	messages, err = xMessages(h.command("XREVRANGE", args...))
+	return
+}
+
+// xread command，streams为stream名称后跟对应的起始ID，如"s1", "s2", "0", "$"
+// count大于0时限制每个stream返回的数量，block为XNoBlock(0)时不阻塞，大于0时最多阻塞block，
+// 为XBlockForever时一直阻塞；阻塞超时没有消息时返回空
+func (h *Helper) XRead(count int64, block time.Duration, streams ...string) (result []XStream, err error) {
+	args := redis.Args{}
+	if count > 0 {
+		args = args.Add("COUNT", count)
+	}
+	if block != XNoBlock {
+		args = args.Add("BLOCK", blockMilliseconds(block))
+	}
+	args = args.Add("STREAMS").AddFlat(streams)
+	if block != XNoBlock {
+		result, err = xStreams(h.blockingCommand(blockTimeout(block), "XREAD", args...))
+	} else {
+		result, err = xStreams(h.command("XREAD", args...))
+	}
+	return
+}
+
+// BLOCK参数的毫秒数，XBlockForever为0，不足1毫秒的阻塞时间向上取整，避免变成一直阻塞
+func blockMilliseconds(block time.Duration) int64 {
+	if block < 0 {
+		return 0
+	}
+	return int64((block + time.Millisecond - 1) / time.Millisecond)
+}
+
+// 阻塞命令的读超时参数，XBlockForever为0表示一直等待
+func blockTimeout(block time.Duration) time.Duration {
+	if block < 0 {
+		return 0
+	}
+	return block
+}
+
+// xgroup create command，start为"$"时只消费新消息，为"0"时消费所有消息，mkStream为true时stream不存在会被创建
+func (h *Helper) XGroupCreate(stream, group, start string, mkStream bool) (err error) {
+	args := redis.Args{}.Add("CREATE", stream, group, start)
+	if mkStream {
+		args = args.Add("MKSTREAM")
+	}
+	_, err = h.command("XGROUP", args...)
+	return
+}
+
+// xgroup destroy command
+func (h *Helper) XGroupDestroy(stream, group string) (err error) {
+	_, err = h.command("XGROUP", "DESTROY", stream, group)
+	return
+}
+
+// xreadgroup command，streams为stream名称后跟对应的起始ID，ID为">"时读取新消息，其他ID读取该消费者的待确认消息
+// count大于0时限制每个stream返回的数量，block为XNoBlock(0)时不阻塞，大于0时最多阻塞block，
+// 为XBlockForever时一直阻塞；阻塞超时没有消息时返回空
+func (h *Helper) XReadGroup(group, consumer string, count int64, block time.Duration, streams ...string) (result []XStream, err error) {
+	args := redis.Args{}.Add("GROUP", group, consumer)
+	if count > 0 {
+		args = args.Add("COUNT", count)
+	}
+	if block != XNoBlock {
+		args = args.Add("BLOCK", blockMilliseconds(block))
+	}
+	args = args.Add("STREAMS").AddFlat(streams)
+	if block != XNoBlock {
+		result, err = xStreams(h.blockingCommand(blockTimeout(block), "XREADGROUP", args...))
+	} else {
+		result, err = xStreams(h.command("XREADGROUP", args...))
+	}
+	return
+}
+
+// xack command，返回确认成功的消息数量
+func (h *Helper) XAck(stream, group string, ids ...string) (acked int64, err error) {
+	acked, err = redis.Int64(h.command("XACK", redis.Args{}.Add(stream, group).AddFlat(ids)...))
+	return
+}
+
+// xpending command，获取消费组待确认消息的概况
+func (h *Helper) XPending(stream, group string) (summary XPendingSummary, err error) {
+	var values []interface{}
+	if values, err = redis.Values(h.command("XPENDING", stream, group)); err != nil {
+		return
+	}
+	if len(values) < 4 {
+		err = fmt.Errorf("redis: unexpected XPENDING reply %v", values)
+		return
+	}
+	summary.Count, _ = redis.Int64(values[0], nil)
+	summary.Lower, _ = redis.String(values[1], nil)
+	summary.Upper, _ = redis.String(values[2], nil)
+	summary.Consumers = make(map[string]int64)
+	consumers, _ := redis.Values(values[3], nil)
+	for _, item := range consumers {
+		var pair []string
+		if pair, err = redis.Strings(item, nil); err != nil || len(pair) != 2 {
+			err = fmt.Errorf("redis: unexpected XPENDING consumer %v", item)
+			return
+		}
+		var count int64
+		if count, err = redis.Int64([]byte(pair[1]), nil); err != nil {
+			return
+		}
+		summary.Consumers[pair[0]] = count
+	}
+	return
+}
+
+// xpending command的扩展形式，获取start到end之间最多count条待确认消息的详情，可以指定消费者
+func (h *Helper) XPendingExt(stream, group, start, end string, count int64, consumer ...string) (entries []XPendingEntry, err error) {
+	args := redis.Args{}.Add(stream, group, start, end, count)
+	if len(consumer) > 0 {
+		args = args.Add(consumer[0])
+	}
+	var values []interface{}
+	if values, err = redis.Values(h.command("XPENDING", args...)); err != nil {
+		return
+	}
+	entries = make([]XPendingEntry, 0, len(values))
+	for _, item := range values {
+		var (
+			fields []interface{}
+			entry  XPendingEntry
+			idle   int64
+		)
+		if fields, err = redis.Values(item, nil); err != nil || len(fields) < 4 {
+			return nil, fmt.Errorf("redis: unexpected XPENDING entry %v", item)
+		}
+		if _, err = redis.Scan(fields, &entry.ID, &entry.Consumer, &idle, &entry.RetryCount); err != nil {
+			return
+		}
+		entry.Idle = time.Duration(idle) * time.Millisecond
+		entries = append(entries, entry)
+	}
+	return
+}
+
+// xclaim command，将空闲时间超过minIdle的待确认消息转移给consumer，返回转移成功的消息
+func (h *Helper) XClaim(stream, group, consumer string, minIdle time.Duration, ids ...string) (messages []XMessage, err error) {
+	args := redis.Args{}.Add(stream, group, consumer, int64(minIdle/time.Millisecond)).AddFlat(ids)
+	messages, err = xMessages(h.command("XCLAIM", args...))
+	return
+}
+
+// xautoclaim command，从start开始扫描最多count条空闲时间超过minIdle的待确认消息并转移给consumer
+// 返回下一次扫描的起始ID("0-0"表示扫描完毕)以及转移成功的消息，需要redis 6.2以上
+func (h *Helper) XAutoClaim(stream, group, consumer string, minIdle time.Duration, start string, count int64) (next string, messages []XMessage, err error) {
+	args := redis.Args{}.Add(stream, group, consumer, int64(minIdle/time.Millisecond), start)
+	if count > 0 {
+		args = args.Add("COUNT", count)
+	}
+	var values []interface{}
+	if values, err = redis.Values(h.command("XAUTOCLAIM", args...)); err != nil {
+		return
+	}
+	if len(values) < 2 {
+		err = fmt.Errorf("redis: unexpected XAUTOCLAIM reply %v", values)
+		return
+	}
+	if next, err = redis.String(values[0], nil); err != nil {
+		return
+	}
+	messages, err = xMessages(values[1], nil)
+	return
+}
+
+// xtrim command，将stream裁剪到maxLen条消息，approx为true时使用"~"近似裁剪，效率更高
+func (h *Helper) XTrim(stream string, maxLen int64, approx bool) (deleted int64, err error) {
+	args := redis.Args{}.Add(stream, "MAXLEN")
+	if approx {
+		args = args.Add("~")
+	}
+	deleted, err = redis.Int64(h.command("XTRIM", args.Add(maxLen)...))
+	return
+}
+
+// 解析消息列表，已经被删除的消息(XCLAIM中为nil)会被忽略
+func xMessages(reply interface{}, err error) ([]XMessage, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]XMessage, 0, len(values))
+	for _, item := range values {
+		if item == nil {
+			continue
+		}
+		var fields []interface{}
+		if fields, err = redis.Values(item, nil); err != nil || len(fields) != 2 {
+			return nil, fmt.Errorf("redis: unexpected stream message %v", item)
+		}
+		var msg XMessage
+		if msg.ID, err = redis.String(fields[0], nil); err != nil {
+			return nil, err
+		}
+		if fields[1] == nil {
+			continue
+		}
+		if msg.Values, err = redis.StringMap(fields[1], nil); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// 解析XREAD/XREADGROUP的返回，超时没有消息时返回空
+func xStreams(reply interface{}, err error) ([]XStream, error) {
+	values, err := redis.Values(reply, err)
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	streams := make([]XStream, 0, len(values))
+	for i := 0; i < len(values); i++ {
+		var fields []interface{}
+		if _, nested := values[i].([]interface{}); !nested && i+1 < len(values) {
+			// RESP3下的返回是stream名称到消息的map，经过resp2Reply转换后为key-value交替的数组
+			fields = values[i : i+2]
+			i++
+		} else if fields, err = redis.Values(values[i], nil); err != nil || len(fields) != 2 {
+			return nil, fmt.Errorf("redis: unexpected stream reply %v", values[i])
+		}
+		var stream XStream
+		if stream.Stream, err = redis.String(fields[0], nil); err != nil {
+			return nil, err
+		}
+		if stream.Messages, err = xMessages(fields[1], nil); err != nil {
+			return nil, err
+		}
+		streams = append(streams, stream)
+	}
+	return streams, nil
+}
+
+// 消费组的消费者配置
+type StreamConsumer struct {
+	Stream   string
+	Group    string
+	Consumer string
+	// 处理消息，返回nil时消息会被确认，返回error时消息保留在待确认列表中，空闲超过ClaimMinIdle后重新处理
+	Handler func(msg XMessage) error
+	// 每次读取的最大消息数量，默认10
+	Count int64
+	// 每次阻塞等待新消息的时间，默认5秒
+	Block time.Duration
+	// 待确认消息空闲超过该时间后会被当前消费者认领并重新处理，默认1分钟，小于0时不认领
+	ClaimMinIdle time.Duration
+	// 检查需要认领的待确认消息的间隔，默认30秒
+	ClaimInterval time.Duration
+}
+
+const (
+	defaultConsumerCount         = 10
+	defaultConsumerBlock         = time.Second * 5
+	defaultConsumerClaimMinIdle  = time.Minute
+	defaultConsumerClaimInterval = time.Second * 30
+	// 消费出错后重试的等待时间
+	consumerRetryInterval = time.Second
+)
+
+// 启动消费组的消费循环，消费组不存在时会被创建(同时创建stream)，从新消息开始消费
+// 循环阻塞读取新消息交给Handler处理，处理成功的消息会被确认，并定期认领空闲过久的待确认消息重新处理
+// 该方法会一直阻塞直到ctx结束并返回ctx.Err()，一般在单独的goroutine中调用
+func (h *Helper) Consume(ctx context.Context, c StreamConsumer) error {
+	if c.Handler == nil {
+		return fmt.Errorf("redis: stream consumer handler required")
+	}
+	if c.Count <= 0 {
+		c.Count = defaultConsumerCount
+	}
+	if c.Block <= 0 {
+		c.Block = defaultConsumerBlock
+	}
+	if c.ClaimMinIdle == 0 {
+		c.ClaimMinIdle = defaultConsumerClaimMinIdle
+	}
+	if c.ClaimInterval <= 0 {
+		c.ClaimInterval = defaultConsumerClaimInterval
+	}
+
+	h = h.WithContext(ctx)
+	if err := h.XGroupCreate(c.Stream, c.Group, "$", true); err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	var lastClaim time.Time
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// 认领失败(如redis 6.2以下不支持XAUTOCLAIM)时只输出警告，等待下一个ClaimInterval再认领，不影响读取新消息
+		if c.ClaimMinIdle > 0 && time.Since(lastClaim) >= c.ClaimInterval {
+			if claimErr := h.claimStale(c); claimErr != nil && ctx.Err() == nil {
+				fmt.Printf("[warning][redis] stream %s claim fail: %s\n", c.Stream, claimErr.Error())
+			}
+			lastClaim = time.Now()
+		}
+		streams, err := h.XReadGroup(c.Group, c.Consumer, c.Count, c.Block, c.Stream, ">")
+		if err == nil {
+			for _, stream := range streams {
+				h.handleMessages(c, stream.Messages)
+			}
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			fmt.Printf("[warning][redis] stream %s consume fail: %s\n", c.Stream, err.Error())
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(consumerRetryInterval):
+			}
+		}
+	}
+}
+
+// 认领所有空闲超过ClaimMinIdle的待确认消息并处理
+func (h *Helper) claimStale(c StreamConsumer) error {
+	start := "0-0"
+	for {
+		next, messages, err := h.XAutoClaim(c.Stream, c.Group, c.Consumer, c.ClaimMinIdle, start, c.Count)
+		if err != nil {
+			return err
+		}
+		h.handleMessages(c, messages)
+		if next == "0-0" || next == start {
+			return nil
+		}
+		start = next
+	}
+}
+
+// 依次处理消息，处理成功的消息被确认
+func (h *Helper) handleMessages(c StreamConsumer, messages []XMessage) {
+	for _, msg := range messages {
+		if err := c.Handler(msg); err != nil {
+			continue
+		}
+		if _, err := h.XAck(c.Stream, c.Group, msg.ID); err != nil {
+			fmt.Printf("[warning][redis] stream %s ack %s fail: %s\n", c.Stream, msg.ID, err.Error())
+		}
+	}
+}
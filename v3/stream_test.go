@@ -0,0 +1,186 @@
+package goredis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStream(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("stream_test")
+	defer helper.Del("stream_test")
+
+	id, err := helper.XAdd("stream_test", "*", map[string]interface{}{"name": "scofield"})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	helper.XAdd("stream_test", "*", map[string]interface{}{"name": "peng"})
+	if length, err := helper.XLen("stream_test"); err != nil || length != 2 {
+		t.Error("want 2 messages, get ", length, err)
+	}
+
+	messages, err := helper.XRange("stream_test", "-", "+", 1)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(messages) != 1 || messages[0].ID != id || messages[0].Values["name"] != "scofield" {
+		t.Error("unexpected XRANGE result ", messages)
+	}
+
+	streams, err := helper.XRead(10, XNoBlock, "stream_test", "0")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(streams) != 1 || streams[0].Stream != "stream_test" || len(streams[0].Messages) != 2 {
+		t.Error("unexpected XREAD result ", streams)
+	}
+
+	// 阻塞读取的时间超过连接的读超时也不会出错
+	p := NewPool(Config{ReadTimeout: time.Millisecond * 50})
+	defer p.Close()
+	p.SetNode(DefaultNodeName, "redis://:@localhost:6379")
+	start := time.Now()
+	if streams, err = p.NewHelper().XRead(10, time.Millisecond*200, "stream_test", "$"); err != nil || len(streams) != 0 {
+		t.Error("want empty XREAD result, get ", streams, err)
+	} else if time.Since(start) < time.Millisecond*150 {
+		t.Error("want XREAD block")
+	}
+	// XBlockForever一直阻塞直到ctx结束
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if _, err = p.NewHelper().WithContext(ctx).XRead(10, XBlockForever, "stream_test", "$"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("want context.DeadlineExceeded, get ", err)
+	}
+
+	if deleted, err := helper.XTrim("stream_test", 1, false); err != nil || deleted != 1 {
+		t.Error("want 1 message trimmed, get ", deleted, err)
+	}
+}
+
+func TestStreamGroup(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("stream_group")
+	defer helper.Del("stream_group")
+
+	if err := helper.XGroupCreate("stream_group", "group", "$", true); err != nil {
+		t.Error(err.Error())
+		return
+	}
+	id, _ := helper.XAdd("stream_group", "*", map[string]interface{}{"job": "1"})
+
+	streams, err := helper.XReadGroup("group", "consumer1", 10, XNoBlock, "stream_group", ">")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(streams) != 1 || len(streams[0].Messages) != 1 || streams[0].Messages[0].ID != id {
+		t.Error("unexpected XREADGROUP result ", streams)
+		return
+	}
+
+	summary, err := helper.XPending("stream_group", "group")
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if summary.Count != 1 || summary.Consumers["consumer1"] != 1 {
+		t.Error("unexpected XPENDING result ", summary)
+	}
+	entries, err := helper.XPendingExt("stream_group", "group", "-", "+", 10)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(entries) != 1 || entries[0].ID != id || entries[0].Consumer != "consumer1" || entries[0].RetryCount != 1 {
+		t.Error("unexpected XPENDING entries ", entries)
+	}
+
+	messages, err := helper.XClaim("stream_group", "group", "consumer2", 0, id)
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if len(messages) != 1 || messages[0].Values["job"] != "1" {
+		t.Error("unexpected XCLAIM result ", messages)
+	}
+
+	if acked, err := helper.XAck("stream_group", "group", id); err != nil || acked != 1 {
+		t.Error("want 1 message acked, get ", acked, err)
+	}
+}
+
+func TestConsume(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("stream_consume")
+	defer helper.Del("stream_consume")
+
+	var (
+		mu       sync.Mutex
+		attempts = make(map[string]int)
+		done     = make(chan struct{})
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- helper.Consume(ctx, StreamConsumer{
+			Stream:        "stream_consume",
+			Group:         "group",
+			Consumer:      "consumer",
+			Block:         time.Millisecond * 100,
+			ClaimMinIdle:  time.Millisecond * 100,
+			ClaimInterval: time.Millisecond * 100,
+			Handler: func(msg XMessage) error {
+				mu.Lock()
+				defer mu.Unlock()
+				attempts[msg.Values["job"]]++
+				// 第一次处理失败，认领后重新处理成功
+				if attempts[msg.Values["job"]] == 1 {
+					return errors.New("retry")
+				}
+				close(done)
+				return nil
+			},
+		})
+	}()
+
+	// 等待消费组创建
+	for i := 0; i < 50; i++ {
+		if exist, _ := helper.Exists("stream_consume"); exist {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	helper.XAdd("stream_consume", "*", map[string]interface{}{"job": "1"})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Error("wait message reclaimed timeout")
+	}
+	// 等待消息被确认
+	var summary XPendingSummary
+	for i := 0; i < 50; i++ {
+		if summary, _ = helper.XPending("stream_consume", "group"); summary.Count == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	if summary.Count != 0 {
+		t.Error("want no pending message, get ", summary)
+	}
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Error("want context canceled, get ", err)
+	}
+}
@@ -0,0 +1,133 @@
+package goredis
+
+import (
+	"context"
+	"errors"
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	// 重试Config.TxMaxRetries次之后事务仍然因为WATCH的key被修改而失败
+	ErrTxFailed = errors.New("redis: transaction failed, watched keys changed")
+)
+
+// 事务，回调函数中通过Command读取数据，通过与Helper同名的方法将写命令加入队列，
+// 回调返回后队列中的命令在MULTI/EXEC中执行，命令的结果在EXEC之后通过PipelineCmd获取
+// 事务独占一个连接，只能在回调函数中使用
+type Tx struct {
+	cmdQueue
+	conn redis.Conn
+	ctx  context.Context
+}
+
+// 在事务的连接上立即执行命令，一般用于读取WATCH的key
+func (tx *Tx) Command(command string, args ...interface{}) (interface{}, error) {
+	return resp2Reply(connDo(tx.ctx, tx.conn, command, args...))
+}
+
+// 在helper绑定的节点上执行事务，见Pool.Tx
+func (h *Helper) Tx(ctx context.Context, watchKeys []string, fn func(tx *Tx) error) error {
+	return h.pool.Tx(ctx, h.nodeName, watchKeys, fn)
+}
+
+// 执行事务：WATCH watchKeys后调用fn，fn返回nil时将fn中加入队列的命令通过MULTI/EXEC执行
+// WATCH的key在EXEC前被修改时，重新WATCH并再次调用fn，最多重试节点配置的TxMaxRetries次，之后返回ErrTxFailed
+// fn返回error时放弃事务并返回该error；EXEC中某条命令执行出错时返回第一个出错命令的错误
+// 集群节点上所有WATCH的key和写入的key需要在同一个slot中
+func (p *Pool) Tx(ctx context.Context, nodeName string, watchKeys []string, fn func(tx *Tx) error) (err error) {
+	var node *Node
+	if node, err = p.GetNode(nodeName); err != nil {
+		return
+	}
+
+	retries := node.GetConfig().TxMaxRetries
+	if retries < 0 {
+		retries = 0
+	}
+	for i := 0; i <= retries; i++ {
+		var done bool
+		if done, err = node.tx(ctx, watchKeys, fn); done || err != nil {
+			return
+		}
+	}
+	return ErrTxFailed
+}
+
+// 执行一次事务，WATCH的key被修改导致EXEC返回nil时done为false
+func (n *Node) tx(ctx context.Context, watchKeys []string, fn func(tx *Tx) error) (done bool, err error) {
+	var conn redis.Conn
+	if conn, err = n.txConn(ctx, watchKeys); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if len(watchKeys) > 0 {
+		if _, err = connDo(ctx, conn, "WATCH", redis.Args{}.AddFlat(watchKeys)...); err != nil {
+			return
+		}
+	}
+
+	tx := &Tx{conn: conn, ctx: ctx}
+	if err = fn(tx); err != nil || len(tx.cmds) == 0 {
+		if len(watchKeys) > 0 {
+			conn.Do("UNWATCH")
+		}
+		return err == nil, err
+	}
+
+	// EXEC中的EVALSHA返回NOSCRIPT时无法重试，因此在MULTI之前加载脚本
+	for _, cmd := range tx.cmds {
+		if cmd.script != nil {
+			if _, err = connDo(ctx, conn, "SCRIPT", "LOAD", cmd.script.src); err != nil {
+				return
+			}
+		}
+	}
+	conn.Send("MULTI")
+	for _, cmd := range tx.cmds {
+		conn.Send(cmd.command, cmd.args...)
+	}
+	var replies []interface{}
+	replies, err = redis.Values(resp2Reply(connDo(ctx, conn, "EXEC")))
+	if err == redis.ErrNil {
+		return false, nil
+	}
+	if err != nil {
+		setCmdsErr(tx.cmds, err)
+		return
+	}
+
+	done = true
+	for i, cmd := range tx.cmds {
+		if i >= len(replies) {
+			break
+		}
+		cmd.reply = replies[i]
+		if replyErr, ok := replies[i].(redis.Error); ok {
+			cmd.reply, cmd.err = nil, replyErr
+			if err == nil {
+				err = replyErr
+			}
+		}
+	}
+	return
+}
+
+// 获取执行事务的连接，集群节点使用第一个WATCH的key所在分片的连接
+func (n *Node) txConn(ctx context.Context, watchKeys []string) (conn redis.Conn, err error) {
+	cluster := n.getCluster()
+	if cluster == nil {
+		return n.GetConnContext(ctx)
+	}
+
+	var addr string
+	if len(watchKeys) > 0 {
+		addr, err = cluster.slotAddr(Slot(watchKeys[0]))
+	} else {
+		addr, err = cluster.randomAddr()
+	}
+	if err != nil {
+		return
+	}
+	return getConnContext(ctx, cluster.getPool(addr))
+}
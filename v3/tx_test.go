@@ -0,0 +1,94 @@
+package goredis
+
+import (
+	"context"
+	"errors"
+	"github.com/gomodule/redigo/redis"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	if err := helper.Set("tx_counter", 10); err != nil {
+		t.Error(err.Error())
+		return
+	}
+
+	var incr *PipelineCmd
+	attempts := 0
+	err := helper.Tx(context.Background(), []string{"tx_counter"}, func(tx *Tx) error {
+		attempts++
+		value, err := redis.Int(tx.Command("GET", "tx_counter"))
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			// 在其他连接上修改WATCH的key，EXEC会失败并重试
+			if err = helper.Set("tx_counter", value+100); err != nil {
+				return err
+			}
+		}
+		tx.Set("tx_counter", value*2)
+		incr = tx.Incr("tx_counter")
+		return nil
+	})
+	if err != nil {
+		t.Error(err.Error())
+		return
+	}
+	if attempts != 2 {
+		t.Error("want 2 attempts, get ", attempts)
+	}
+	if value, err := incr.Int64(); err != nil || value != 221 {
+		t.Error("want 221, get ", value, err)
+	}
+	helper.Del("tx_counter")
+}
+
+func TestTxAbort(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	abort := errors.New("abort")
+	err := helper.Tx(context.Background(), []string{"tx_abort"}, func(tx *Tx) error {
+		tx.Set("tx_abort", "value")
+		return abort
+	})
+	if err != abort {
+		t.Error("want abort, get ", err)
+	}
+	if exist, _ := helper.Exists("tx_abort"); exist {
+		t.Error("want tx_abort not set")
+	}
+
+	attempts := 0
+	err = helper.Tx(context.Background(), []string{"tx_abort"}, func(tx *Tx) error {
+		attempts++
+		if err := helper.Set("tx_abort", attempts); err != nil {
+			return err
+		}
+		tx.Del("tx_abort")
+		return nil
+	})
+	if err != ErrTxFailed || attempts != DefaultTxMaxRetries+1 {
+		t.Error("want ErrTxFailed after ", DefaultTxMaxRetries+1, " attempts, get ", err, attempts)
+	}
+
+	// 每个Pool使用自己配置的重试次数
+	p := NewPool(Config{TxMaxRetries: -1})
+	defer p.Close()
+	p.SetNode(DefaultNodeName, "redis://:@localhost:6379")
+	attempts = 0
+	err = p.NewHelper().Tx(context.Background(), []string{"tx_abort"}, func(tx *Tx) error {
+		attempts++
+		if err := helper.Set("tx_abort", attempts); err != nil {
+			return err
+		}
+		tx.Del("tx_abort")
+		return nil
+	})
+	if err != ErrTxFailed || attempts != 1 {
+		t.Error("want ErrTxFailed without retry, get ", err, attempts)
+	}
+	helper.Del("tx_abort")
+}
@@ -0,0 +1,112 @@
+package goredis
+
+import (
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	// key或field不存在，由返回具体类型的方法(如GetString)返回
+	ErrNil = redis.ErrNil
+)
+
+// get command，返回string，key不存在时返回ErrNil
+func (h *Helper) GetString(key string) (value string, err error) {
+	value, err = redis.String(h.command("GET", key))
+	return
+}
+
+// get command，返回int64，key不存在时返回ErrNil
+func (h *Helper) GetInt64(key string) (value int64, err error) {
+	value, err = redis.Int64(h.command("GET", key))
+	return
+}
+
+// get command，返回float64，key不存在时返回ErrNil
+func (h *Helper) GetFloat64(key string) (value float64, err error) {
+	value, err = redis.Float64(h.command("GET", key))
+	return
+}
+
+// get command，返回[]byte，key不存在时返回ErrNil
+func (h *Helper) GetBytes(key string) (value []byte, err error) {
+	value, err = redis.Bytes(h.command("GET", key))
+	return
+}
+
+// getset command，返回旧值，key之前不存在时返回ErrNil(新值仍然会被设置)
+func (h *Helper) GetSetString(key string, value interface{}) (oldValue string, err error) {
+	oldValue, err = redis.String(h.command("GETSET", key, value))
+	return
+}
+
+// mget command，返回key到值的映射，不存在的key不会出现在结果中
+func (h *Helper) MGetMap(keys ...string) (values map[string]string, err error) {
+	values = make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return
+	}
+	var replies []interface{}
+	if replies, err = redis.Values(h.command("MGET", redis.Args{}.AddFlat(keys)...)); err != nil {
+		return nil, err
+	}
+	for i, reply := range replies {
+		if reply == nil || i >= len(keys) {
+			continue
+		}
+		if values[keys[i]], err = redis.String(reply, nil); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// hget command，返回string，key或field不存在时返回ErrNil
+func (h *Helper) HGetString(key, field string) (value string, err error) {
+	value, err = redis.String(h.command("HGET", key, field))
+	return
+}
+
+// hget command，返回int64，key或field不存在时返回ErrNil
+func (h *Helper) HGetInt64(key, field string) (value int64, err error) {
+	value, err = redis.Int64(h.command("HGET", key, field))
+	return
+}
+
+// hget command，返回[]byte，key或field不存在时返回ErrNil
+func (h *Helper) HGetBytes(key, field string) (value []byte, err error) {
+	value, err = redis.Bytes(h.command("HGET", key, field))
+	return
+}
+
+// hgetall command，返回field到值的映射，key不存在时返回空map
+func (h *Helper) HGetAllMap(key string) (values map[string]string, err error) {
+	values, err = redis.StringMap(h.command("HGETALL", key))
+	return
+}
+
+// hmget command，返回field到值的映射，不存在的field不会出现在结果中
+func (h *Helper) HMGetMap(key string, fields ...string) (values map[string]string, err error) {
+	values = make(map[string]string, len(fields))
+	if len(fields) == 0 {
+		return
+	}
+	var replies []interface{}
+	if replies, err = redis.Values(h.command("HMGET", redis.Args{}.Add(key).AddFlat(fields)...)); err != nil {
+		return nil, err
+	}
+	for i, reply := range replies {
+		if reply == nil || i >= len(fields) {
+			continue
+		}
+		if values[fields[i]], err = redis.String(reply, nil); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// hvals command，返回[]string
+func (h *Helper) HValsStrings(key string) (values []string, err error) {
+	values, err = redis.Strings(h.command("HVALS", key))
+	return
+}
@@ -0,0 +1,79 @@
+package goredis
+
+import (
+	"testing"
+)
+
+func TestTypedGet(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("typed_string", "typed_number", "typed_not_exist")
+	defer helper.Del("typed_string", "typed_number")
+
+	helper.Set("typed_string", "scofield")
+	helper.Set("typed_number", 42)
+
+	if value, err := helper.GetString("typed_string"); err != nil || value != "scofield" {
+		t.Error("want scofield, get ", value, err)
+	}
+	if value, err := helper.GetBytes("typed_string"); err != nil || string(value) != "scofield" {
+		t.Error("want scofield, get ", string(value), err)
+	}
+	if value, err := helper.GetInt64("typed_number"); err != nil || value != 42 {
+		t.Error("want 42, get ", value, err)
+	}
+	if value, err := helper.GetFloat64("typed_number"); err != nil || value != 42 {
+		t.Error("want 42, get ", value, err)
+	}
+	if _, err := helper.GetString("typed_not_exist"); err != ErrNil {
+		t.Error("want ErrNil, get ", err)
+	}
+	if _, err := helper.GetInt64("typed_string"); err == nil || err == ErrNil {
+		t.Error("want conversion error, get ", err)
+	}
+	if old, err := helper.GetSetString("typed_number", 43); err != nil || old != "42" {
+		t.Error("want 42, get ", old, err)
+	}
+
+	values, err := helper.MGetMap("typed_string", "typed_not_exist", "typed_number")
+	if err != nil || len(values) != 2 || values["typed_string"] != "scofield" || values["typed_number"] != "43" {
+		t.Error("unexpected MGET result ", values, err)
+	}
+}
+
+func TestTypedHash(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("typed_hash")
+	defer helper.Del("typed_hash")
+
+	helper.HMset("typed_hash", map[string]interface{}{"name": "scofield", "age": 18})
+
+	if value, err := helper.HGetString("typed_hash", "name"); err != nil || value != "scofield" {
+		t.Error("want scofield, get ", value, err)
+	}
+	if value, err := helper.HGetInt64("typed_hash", "age"); err != nil || value != 18 {
+		t.Error("want 18, get ", value, err)
+	}
+	if value, err := helper.HGetBytes("typed_hash", "name"); err != nil || string(value) != "scofield" {
+		t.Error("want scofield, get ", string(value), err)
+	}
+	if _, err := helper.HGetString("typed_hash", "not_exist"); err != ErrNil {
+		t.Error("want ErrNil, get ", err)
+	}
+
+	all, err := helper.HGetAllMap("typed_hash")
+	if err != nil || len(all) != 2 || all["name"] != "scofield" || all["age"] != "18" {
+		t.Error("unexpected HGETALL result ", all, err)
+	}
+	if all, err = helper.HGetAllMap("typed_not_exist"); err != nil || len(all) != 0 {
+		t.Error("want empty map, get ", all, err)
+	}
+	values, err := helper.HMGetMap("typed_hash", "name", "not_exist")
+	if err != nil || len(values) != 1 || values["name"] != "scofield" {
+		t.Error("unexpected HMGET result ", values, err)
+	}
+	if vals, err := helper.HValsStrings("typed_hash"); err != nil || len(vals) != 2 {
+		t.Error("want 2 values, get ", vals, err)
+	}
+}
@@ -0,0 +1,230 @@
+package goredis
+
+import (
+	"fmt"
+	"github.com/gomodule/redigo/redis"
+	"strconv"
+)
+
+// 有序集合的成员和分数
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// ZADD的选项
+type ZAddOptions struct {
+	// 只添加新成员，不更新已经存在的成员
+	NX bool
+	// 只更新已经存在的成员，不添加新成员
+	XX bool
+	// 只在新分数大于当前分数时更新，需要redis 6.2以上
+	GT bool
+	// 只在新分数小于当前分数时更新，需要redis 6.2以上
+	LT bool
+	// 返回发生变化(新增和分数被更新)的成员数量，而不只是新增的成员数量
+	CH bool
+}
+
+// 将选项转换为ZADD的参数
+func (o ZAddOptions) args() redis.Args {
+	args := redis.Args{}
+	if o.NX {
+		args = args.Add("NX")
+	}
+	if o.XX {
+		args = args.Add("XX")
+	}
+	if o.GT {
+		args = args.Add("GT")
+	}
+	if o.LT {
+		args = args.Add("LT")
+	}
+	if o.CH {
+		args = args.Add("CH")
+	}
+	return args
+}
+
+// zadd command，返回新增的成员数量
+func (h *Helper) ZAdd(key string, members ...ZMember) (added int64, err error) {
+	return h.ZAddWithOptions(key, ZAddOptions{}, members...)
+}
+
+// 带选项的zadd command，返回新增的成员数量，设置了CH时返回发生变化的成员数量
+func (h *Helper) ZAddWithOptions(key string, options ZAddOptions, members ...ZMember) (count int64, err error) {
+	args := redis.Args{}.Add(key).Add(options.args()...)
+	for _, member := range members {
+		args = args.Add(member.Score, member.Member)
+	}
+	count, err = redis.Int64(h.command("ZADD", args...))
+	return
+}
+
+// 带INCR选项的zadd command，将member的分数增加member.Score并返回新的分数
+// 因为NX/XX/GT/LT的限制没有更新时返回redis.ErrNil
+func (h *Helper) ZAddIncr(key string, options ZAddOptions, member ZMember) (score float64, err error) {
+	args := redis.Args{}.Add(key).Add(options.args()...).Add("INCR", member.Score, member.Member)
+	score, err = redis.Float64(h.command("ZADD", args...))
+	return
+}
+
+// zincrby command，返回新的分数
+func (h *Helper) ZIncrBy(key string, increment float64, member string) (score float64, err error) {
+	score, err = redis.Float64(h.command("ZINCRBY", key, increment, member))
+	return
+}
+
+// zrem command，返回移除的成员数量
+func (h *Helper) ZRem(key string, members ...string) (removed int64, err error) {
+	removed, err = redis.Int64(h.command("ZREM", redis.Args{}.Add(key).AddFlat(members)...))
+	return
+}
+
+// zremrangebyscore command，min和max可以使用"-inf"、"+inf"和"("前缀表示开区间
+func (h *Helper) ZRemRangeByScore(key, min, max string) (removed int64, err error) {
+	removed, err = redis.Int64(h.command("ZREMRANGEBYSCORE", key, min, max))
+	return
+}
+
+// zremrangebyrank command
+func (h *Helper) ZRemRangeByRank(key string, start, stop int64) (removed int64, err error) {
+	removed, err = redis.Int64(h.command("ZREMRANGEBYRANK", key, start, stop))
+	return
+}
+
+// zcard command
+func (h *Helper) ZCard(key string) (count int64, err error) {
+	count, err = redis.Int64(h.command("ZCARD", key))
+	return
+}
+
+// zcount command，min和max可以使用"-inf"、"+inf"和"("前缀表示开区间
+func (h *Helper) ZCount(key, min, max string) (count int64, err error) {
+	count, err = redis.Int64(h.command("ZCOUNT", key, min, max))
+	return
+}
+
+// zscore command，成员不存在时返回redis.ErrNil
+func (h *Helper) ZScore(key, member string) (score float64, err error) {
+	score, err = redis.Float64(h.command("ZSCORE", key, member))
+	return
+}
+
+// zrank command，按照分数从小到大的排名(从0开始)，成员不存在时返回redis.ErrNil
+func (h *Helper) ZRank(key, member string) (rank int64, err error) {
+	rank, err = redis.Int64(h.command("ZRANK", key, member))
+	return
+}
+
+// zrevrank command，按照分数从大到小的排名(从0开始)，成员不存在时返回redis.ErrNil
+func (h *Helper) ZRevRank(key, member string) (rank int64, err error) {
+	rank, err = redis.Int64(h.command("ZREVRANK", key, member))
+	return
+}
+
+// zrange command
+func (h *Helper) ZRange(key string, start, stop int64) (members []string, err error) {
+	members, err = redis.Strings(h.command("ZRANGE", key, start, stop))
+	return
+}
+
+// zrange command with WITHSCORES
+func (h *Helper) ZRangeWithScores(key string, start, stop int64) (members []ZMember, err error) {
+	members, err = zMembers(h.command("ZRANGE", key, start, stop, "WITHSCORES"))
+	return
+}
+
+// zrevrange command
+func (h *Helper) ZRevRange(key string, start, stop int64) (members []string, err error) {
+	members, err = redis.Strings(h.command("ZREVRANGE", key, start, stop))
+	return
+}
+
+// zrevrange command with WITHSCORES
+func (h *Helper) ZRevRangeWithScores(key string, start, stop int64) (members []ZMember, err error) {
+	members, err = zMembers(h.command("ZREVRANGE", key, start, stop, "WITHSCORES"))
+	return
+}
+
+// zrangebyscore command，min和max可以使用"-inf"、"+inf"和"("前缀表示开区间，limit为offset和count
+func (h *Helper) ZRangeByScore(key, min, max string, limit ...int64) (members []string, err error) {
+	members, err = redis.Strings(h.command("ZRANGEBYSCORE", zRangeArgs(key, min, max, false, limit)...))
+	return
+}
+
+// zrangebyscore command with WITHSCORES，见ZRangeByScore
+func (h *Helper) ZRangeByScoreWithScores(key, min, max string, limit ...int64) (members []ZMember, err error) {
+	members, err = zMembers(h.command("ZRANGEBYSCORE", zRangeArgs(key, min, max, true, limit)...))
+	return
+}
+
+// zrevrangebyscore command，注意max在min之前，limit为offset和count
+func (h *Helper) ZRevRangeByScore(key, max, min string, limit ...int64) (members []string, err error) {
+	members, err = redis.Strings(h.command("ZREVRANGEBYSCORE", zRangeArgs(key, max, min, false, limit)...))
+	return
+}
+
+// zrevrangebyscore command with WITHSCORES，见ZRevRangeByScore
+func (h *Helper) ZRevRangeByScoreWithScores(key, max, min string, limit ...int64) (members []ZMember, err error) {
+	members, err = zMembers(h.command("ZREVRANGEBYSCORE", zRangeArgs(key, max, min, true, limit)...))
+	return
+}
+
+// zpopmin command，移除并返回分数最小的count个成员，没有指定count时为1个
+func (h *Helper) ZPopMin(key string, count ...int64) (members []ZMember, err error) {
+	members, err = zMembers(h.command("ZPOPMIN", redis.Args{}.Add(key).AddFlat(count)...))
+	return
+}
+
+// zpopmax command，移除并返回分数最大的count个成员，没有指定count时为1个
+func (h *Helper) ZPopMax(key string, count ...int64) (members []ZMember, err error) {
+	members, err = zMembers(h.command("ZPOPMAX", redis.Args{}.Add(key).AddFlat(count)...))
+	return
+}
+
+// 按照分数范围查询的参数
+func zRangeArgs(key, from, to string, withScores bool, limit []int64) redis.Args {
+	args := redis.Args{}.Add(key, from, to)
+	if withScores {
+		args = args.Add("WITHSCORES")
+	}
+	if len(limit) >= 2 {
+		args = args.Add("LIMIT", limit[0], limit[1])
+	}
+	return args
+}
+
+// 解析WITHSCORES返回的成员和分数
+func zMembers(reply interface{}, err error) ([]ZMember, error) {
+	replies, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	// RESP3下每个成员和分数是一个二元数组
+	flat := make([]interface{}, 0, len(replies)*2)
+	for _, item := range replies {
+		if pair, ok := item.([]interface{}); ok {
+			flat = append(flat, pair...)
+		} else {
+			flat = append(flat, item)
+		}
+	}
+	values, err := redis.Strings(flat, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%2 != 0 {
+		return nil, fmt.Errorf("redis: unexpected WITHSCORES reply length %d", len(values))
+	}
+	members := make([]ZMember, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		score, err := strconv.ParseFloat(values[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, ZMember{Member: values[i], Score: score})
+	}
+	return members, nil
+}
@@ -0,0 +1,73 @@
+package goredis
+
+import (
+	"github.com/gomodule/redigo/redis"
+	"testing"
+)
+
+func TestZSet(t *testing.T) {
+	testInit()
+	helper := NewHelper()
+	helper.Del("zset_test")
+	defer helper.Del("zset_test")
+
+	added, err := helper.ZAdd("zset_test", ZMember{"alice", 10}, ZMember{"bob", 20}, ZMember{"carol", 30})
+	if err != nil || added != 3 {
+		t.Error("want 3 added, get ", added, err)
+		return
+	}
+	// GT只在分数变大时更新，CH返回发生变化的数量
+	if changed, err := helper.ZAddWithOptions("zset_test", ZAddOptions{GT: true, CH: true},
+		ZMember{"alice", 5}, ZMember{"bob", 25}); err != nil || changed != 1 {
+		t.Error("want 1 changed, get ", changed, err)
+	}
+	if score, err := helper.ZAddIncr("zset_test", ZAddOptions{XX: true}, ZMember{"alice", 1.5}); err != nil || score != 11.5 {
+		t.Error("want 11.5, get ", score, err)
+	}
+	if _, err := helper.ZAddIncr("zset_test", ZAddOptions{XX: true}, ZMember{"dave", 1}); err != redis.ErrNil {
+		t.Error("want ErrNil, get ", err)
+	}
+	if score, err := helper.ZIncrBy("zset_test", 10, "carol"); err != nil || score != 40 {
+		t.Error("want 40, get ", score, err)
+	}
+
+	members, err := helper.ZRangeWithScores("zset_test", 0, -1)
+	if err != nil || len(members) != 3 || members[0] != (ZMember{"alice", 11.5}) || members[2] != (ZMember{"carol", 40}) {
+		t.Error("unexpected ZRANGE WITHSCORES result ", members, err)
+	}
+	if names, err := helper.ZRevRange("zset_test", 0, 0); err != nil || len(names) != 1 || names[0] != "carol" {
+		t.Error("want [carol], get ", names, err)
+	}
+	if names, err := helper.ZRangeByScore("zset_test", "(11.5", "+inf", 0, 1); err != nil || len(names) != 1 || names[0] != "bob" {
+		t.Error("want [bob], get ", names, err)
+	}
+	if members, err = helper.ZRevRangeByScoreWithScores("zset_test", "+inf", "25"); err != nil || len(members) != 2 || members[0].Member != "carol" {
+		t.Error("unexpected ZREVRANGEBYSCORE result ", members, err)
+	}
+
+	if rank, err := helper.ZRank("zset_test", "bob"); err != nil || rank != 1 {
+		t.Error("want rank 1, get ", rank, err)
+	}
+	if rank, err := helper.ZRevRank("zset_test", "bob"); err != nil || rank != 1 {
+		t.Error("want rank 1, get ", rank, err)
+	}
+	if _, err := helper.ZScore("zset_test", "dave"); err != redis.ErrNil {
+		t.Error("want ErrNil, get ", err)
+	}
+	if count, err := helper.ZCount("zset_test", "-inf", "25"); err != nil || count != 2 {
+		t.Error("want 2, get ", count, err)
+	}
+
+	if members, err = helper.ZPopMax("zset_test"); err != nil || len(members) != 1 || members[0].Member != "carol" {
+		t.Error("want carol popped, get ", members, err)
+	}
+	if members, err = helper.ZPopMin("zset_test", 1); err != nil || len(members) != 1 || members[0].Member != "alice" {
+		t.Error("want alice popped, get ", members, err)
+	}
+	if removed, err := helper.ZRemRangeByScore("zset_test", "-inf", "+inf"); err != nil || removed != 1 {
+		t.Error("want 1 removed, get ", removed, err)
+	}
+	if count, err := helper.ZCard("zset_test"); err != nil || count != 0 {
+		t.Error("want empty zset, get ", count, err)
+	}
+}